@@ -0,0 +1,90 @@
+package httplog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// NewDualHandler returns a slog.Handler which writes every record twice: in
+// full as JSON to jsonOut, and as pretty text to prettyOut. This is useful to
+// keep machine readable logs in a file while following along on the console.
+//
+// Each output has its own field naming and level, from jsonOpts and
+// prettyOpts, either of which may be nil to use the defaults. With a Concise
+// prettyOpts, as by default, the pretty output is a one-line human summary of
+// each record (time, level and message, without any attributes). Note that
+// each record is rendered once per output, so logging costs roughly twice as
+// much as with a single handler.
+func NewDualHandler(jsonOut io.Writer, jsonOpts *Options, prettyOut io.Writer, prettyOpts *Options) slog.Handler {
+	if jsonOpts == nil {
+		jsonOpts = &defaultOptions
+	}
+	if prettyOpts == nil {
+		prettyOpts = &defaultOptions
+	}
+
+	var pretty slog.Handler = NewPrettyHandler(prettyOut, prettyOpts.withDefaults().handlerOptions())
+	if prettyOpts.Concise {
+		pretty = &summaryHandler{pretty}
+	}
+	return &dualHandler{
+		full:   slog.NewJSONHandler(jsonOut, jsonOpts.withDefaults().handlerOptions()),
+		pretty: pretty,
+	}
+}
+
+type dualHandler struct {
+	full   slog.Handler
+	pretty slog.Handler
+}
+
+var _ slog.Handler = &dualHandler{}
+
+func (h *dualHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.full.Enabled(ctx, level) || h.pretty.Enabled(ctx, level)
+}
+
+func (h *dualHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	if h.full.Enabled(ctx, r.Level) {
+		errs = append(errs, h.full.Handle(ctx, r.Clone()))
+	}
+	if h.pretty.Enabled(ctx, r.Level) {
+		errs = append(errs, h.pretty.Handle(ctx, r))
+	}
+	return errors.Join(errs...)
+}
+
+func (h *dualHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &dualHandler{
+		full:   h.full.WithAttrs(attrs),
+		pretty: h.pretty.WithAttrs(attrs),
+	}
+}
+
+func (h *dualHandler) WithGroup(name string) slog.Handler {
+	return &dualHandler{
+		full:   h.full.WithGroup(name),
+		pretty: h.pretty.WithGroup(name),
+	}
+}
+
+// summaryHandler drops all attributes, leaving only the time, level and
+// message of each record for the underlying handler to write.
+type summaryHandler struct {
+	handler slog.Handler
+}
+
+func (h *summaryHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *summaryHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, slog.NewRecord(r.Time, r.Level, r.Message, r.PC))
+}
+
+func (h *summaryHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func (h *summaryHandler) WithGroup(name string) slog.Handler { return h }
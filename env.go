@@ -0,0 +1,105 @@
+package httplog
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptionsFromEnv returns the default options, overridden by the environment
+// variables named after prefix, which defaults to "HTTPLOG", ie. for 12-factor
+// apps to tune logging without code changes. The recognized variables are:
+//
+//	HTTPLOG_LEVEL                 LogLevel, by name, see LevelByName
+//	HTTPLOG_JSON                  JSON, ie. "true"
+//	HTTPLOG_CONCISE               Concise
+//	HTTPLOG_REQUEST_HEADERS       RequestHeaders
+//	HTTPLOG_RESPONSE_HEADERS      ResponseHeaders
+//	HTTPLOG_HIDE_REQUEST_HEADERS  HideRequestHeaders, comma separated
+//	HTTPLOG_QUIET_DOWN_ROUTES     QuietDownRoutes, comma separated
+//	HTTPLOG_QUIET_DOWN_PERIOD     QuietDownPeriod, ie. "5m"
+//	HTTPLOG_BODY_LOG_SIZE_LIMIT   BodyLogSizeLimit, in bytes
+//	HTTPLOG_TAGS                  Tags, ie. "env=prod,version=1.2"
+//
+// Invalid values are reported with a warning on slog.Default() and ignored.
+func OptionsFromEnv(prefix string) Options {
+	if prefix == "" {
+		prefix = "HTTPLOG"
+	}
+	opts := defaultOptions
+
+	env := func(name string) (string, bool) {
+		v := strings.TrimSpace(os.Getenv(prefix + "_" + name))
+		return v, v != ""
+	}
+	invalid := func(name, v string, err error) {
+		slog.Default().Warn("httplog: ignoring invalid environment variable",
+			slog.String("name", prefix+"_"+name), slog.String("value", v), ErrAttr(err))
+	}
+	envBool := func(name string, dst *bool) {
+		if v, ok := env(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				invalid(name, v, err)
+				return
+			}
+			*dst = b
+		}
+	}
+	envList := func(name string) []string {
+		v, ok := env(name)
+		if !ok {
+			return nil
+		}
+		list := []string{}
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+
+	if v, ok := env("LEVEL"); ok {
+		if level := LevelByName(v); level != 0 || strings.EqualFold(v, "INFO") {
+			opts.LogLevel = level
+		} else {
+			invalid("LEVEL", v, errors.New("unknown log level"))
+		}
+	}
+	envBool("JSON", &opts.JSON)
+	envBool("CONCISE", &opts.Concise)
+	envBool("REQUEST_HEADERS", &opts.RequestHeaders)
+	envBool("RESPONSE_HEADERS", &opts.ResponseHeaders)
+	if headers := envList("HIDE_REQUEST_HEADERS"); headers != nil {
+		opts.HideRequestHeaders = headers
+	}
+	if routes := envList("QUIET_DOWN_ROUTES"); routes != nil {
+		opts.QuietDownRoutes = routes
+	}
+	if v, ok := env("QUIET_DOWN_PERIOD"); ok {
+		if d, err := time.ParseDuration(v); err != nil {
+			invalid("QUIET_DOWN_PERIOD", v, err)
+		} else {
+			opts.QuietDownPeriod = d
+		}
+	}
+	if v, ok := env("BODY_LOG_SIZE_LIMIT"); ok {
+		if n, err := strconv.Atoi(v); err != nil {
+			invalid("BODY_LOG_SIZE_LIMIT", v, err)
+		} else {
+			opts.BodyLogSizeLimit = n
+		}
+	}
+	if tags := envList("TAGS"); tags != nil {
+		opts.Tags = map[string]string{}
+		for _, tag := range tags {
+			k, v, _ := strings.Cut(tag, "=")
+			opts.Tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return opts
+}
@@ -0,0 +1,105 @@
+package httplog
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// GraphQLAttrs returns the operation name and type ("query", "mutation" or
+// "subscription") of a GraphQL request body, along with the names of its
+// variables, whose values are redacted. It returns nil if the body isn't a
+// GraphQL request, which includes batched requests, whose body is an array of
+// operations: they'd rather be logged one by one, by the handler. Handlers of a single /graphql endpoint can log them to
+// tell requests apart, ie.
+//
+//	httplog.LogEntrySetField(ctx, "graphql", slog.GroupValue(httplog.GraphQLAttrs(body)...))
+func GraphQLAttrs(reqBody string) []slog.Attr {
+	var req struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	if err := json.Unmarshal([]byte(reqBody), &req); err != nil || req.Query == "" {
+		return nil
+	}
+
+	opType, opName := graphQLOperation(req.Query)
+	if req.OperationName != "" {
+		opName = req.OperationName
+	}
+
+	attrs := []slog.Attr{
+		{Key: "operationType", Value: slog.StringValue(opType)},
+	}
+	if opName != "" {
+		attrs = append(attrs, slog.Attr{Key: "operationName", Value: slog.StringValue(opName)})
+	}
+	if len(req.Variables) > 0 {
+		variables := make([]slog.Attr, 0, len(req.Variables))
+		for k := range req.Variables {
+			variables = append(variables, slog.Attr{Key: k, Value: slog.StringValue("***")})
+		}
+		slices.SortFunc(variables, func(a, b slog.Attr) int { return strings.Compare(a.Key, b.Key) })
+		attrs = append(attrs, slog.Attr{Key: "variables", Value: slog.GroupValue(variables...)})
+	}
+	return attrs
+}
+
+// graphQLOperation returns the type and name of the first operation of the
+// GraphQL document, ie. "query", "GetUser" for "query GetUser { ... }". A
+// document starting with a selection set is an anonymous query. Fragment
+// definitions before the operation are skipped.
+func graphQLOperation(query string) (opType, opName string) {
+	// skip comments
+	var b strings.Builder
+	for _, line := range strings.Split(query, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		b.WriteString(line)
+		b.WriteByte(' ')
+	}
+	query = strings.TrimSpace(b.String())
+
+	isNameChar := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	for {
+		if query == "" || query[0] == '{' {
+			return "query", ""
+		}
+		opType = query[:len(query)-len(strings.TrimLeftFunc(query, isNameChar))]
+		if opType != "fragment" {
+			break
+		}
+		query = skipSelectionSet(query)
+	}
+	query = strings.TrimSpace(query[len(opType):])
+	opName = query[:len(query)-len(strings.TrimLeftFunc(query, isNameChar))]
+	return opType, opName
+}
+
+// skipSelectionSet returns what follows the first selection set of the
+// GraphQL document, ie. the rest of the document after a fragment definition.
+func skipSelectionSet(query string) string {
+	depth := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case inString && c == '\\':
+			i++ // escaped character
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return strings.TrimSpace(query[i+1:])
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// handlerSourceCache caches the source location of the handlers of the chi
+// routers served by a Handler, keyed by method and route pattern, see
+// Options.LogHandlerSource. It's released along with the Handler.
+type handlerSourceCache struct {
+	m sync.Map // chi.Routes -> map[string]slog.Attr
+}
+
+// logField returns the source location of the handler which served the
+// request, looked up from the chi route matched for the request.
+func (c *handlerSourceCache) logField(ctx context.Context, method string) (slog.Attr, bool) {
+	rctx := chi.RouteContext(ctx)
+	if rctx == nil || rctx.Routes == nil || rctx.RoutePattern() == "" {
+		return slog.Attr{}, false
+	}
+
+	key := method + " " + normalizeRoutePattern(rctx.RoutePattern())
+	if sources, ok := c.m.Load(rctx.Routes); ok {
+		if attr, ok := sources.(map[string]slog.Attr)[key]; ok {
+			return attr, attr.Key != ""
+		}
+	}
+
+	// the routes are walked again for routes added since they were cached,
+	// though they're usually all set up before the server starts
+	m := map[string]slog.Attr{}
+	chi.Walk(rctx.Routes, func(method, route string, handler http.Handler, _ ...func(http.Handler) http.Handler) error {
+		m[method+" "+normalizeRoutePattern(route)] = handlerSource(handler)
+		return nil
+	})
+	attr, ok := m[key]
+	if !ok {
+		// not to walk the routes again for this route
+		m[key] = slog.Attr{}
+	}
+	c.m.Store(rctx.Routes, m)
+	return attr, ok
+}
+
+// normalizeRoutePattern normalizes the route pattern the way chi does for
+// Context.RoutePattern, so the patterns of chi.Walk can be compared with it.
+func normalizeRoutePattern(pattern string) string {
+	for strings.Contains(pattern, "/*/") {
+		pattern = strings.ReplaceAll(pattern, "/*/", "/")
+	}
+	pattern = strings.TrimSuffix(pattern, "//")
+	return strings.TrimSuffix(pattern, "/")
+}
+
+// handlerSource returns the location of the function of the handler, or the
+// type of the handler when it isn't a function.
+func handlerSource(handler http.Handler) slog.Attr {
+	// routes set up with Router.With are served by a chain of middlewares
+	for {
+		chain, ok := handler.(*chi.ChainHandler)
+		if !ok {
+			break
+		}
+		handler = chain.Endpoint
+	}
+
+	var fn *runtime.Func
+	if v := reflect.ValueOf(handler); v.Kind() == reflect.Func {
+		fn = runtime.FuncForPC(v.Pointer())
+	}
+	if fn == nil {
+		return slog.Group("handlerSource",
+			slog.Attr{Key: "function", Value: slog.StringValue(fmt.Sprintf("%T", handler))})
+	}
+
+	file, line := fn.FileLine(fn.Entry())
+	return slog.Group("handlerSource",
+		slog.Attr{Key: "function", Value: slog.StringValue(fn.Name())},
+		slog.Attr{Key: "file", Value: slog.StringValue(file)},
+		slog.Attr{Key: "line", Value: slog.IntValue(line)},
+	)
+}
@@ -0,0 +1,74 @@
+package httplog
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat periodically logs the progress of a long running request, such
+// as a download or a stream of server-sent events, until it's stopped.
+type heartbeat struct {
+	http.ResponseWriter
+	bytes atomic.Int64
+	done  chan struct{}
+}
+
+// startHeartbeat wraps w to count the bytes written, and starts logging the
+// progress to logger every interval.
+func startHeartbeat(ctx context.Context, logger *slog.Logger, w http.ResponseWriter, interval time.Duration) *heartbeat {
+	hb := &heartbeat{ResponseWriter: w, done: make(chan struct{})}
+	t1 := time.Now()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hb.done:
+				return
+			case <-ticker.C:
+				elapsed := time.Since(t1)
+				logger.LogAttrs(ctx, slog.LevelDebug, "Request in progress",
+					slog.Group("httpResponse",
+						slog.Attr{Key: "bytes", Value: slog.Int64Value(hb.bytes.Load())},
+						slog.Attr{Key: "elapsed", Value: slog.Float64Value(float64(elapsed.Nanoseconds()) / 1000000.0)}, // in milliseconds
+					))
+			}
+		}
+	}()
+	return hb
+}
+
+// Stop stops logging the progress.
+func (hb *heartbeat) Stop() {
+	close(hb.done)
+}
+
+func (hb *heartbeat) Write(p []byte) (int, error) {
+	n, err := hb.ResponseWriter.Write(p)
+	hb.bytes.Add(int64(n))
+	return n, err
+}
+
+func (hb *heartbeat) Flush() {
+	if f, ok := hb.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, ie. for WebSocket upgrades,
+// when the wrapped response writer supports it.
+func (hb *heartbeat) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(hb.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the features, such as
+// hijacking, of the wrapped response writer.
+func (hb *heartbeat) Unwrap() http.ResponseWriter {
+	return hb.ResponseWriter
+}
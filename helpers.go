@@ -3,7 +3,12 @@ package httplog
 import (
 	"encoding/json"
 	"log/slog"
+	"net/http"
 	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 // StructValue will convert a struct or slice of structs to a slog.Value
@@ -24,3 +29,105 @@ func StructValue(v interface{}) slog.Value {
 
 	return slog.AnyValue(out)
 }
+
+// HandlerFunc adapts a handler which returns an error into an http.Handler.
+// A returned error is set on the request log entry as the "err" field and,
+// unless the handler already wrote a response, answered with the status from
+// statusFunc, or 500 Internal Server Error if statusFunc is nil.
+func HandlerFunc(fn func(w http.ResponseWriter, r *http.Request) error, statusFunc func(err error) int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww, ok := w.(middleware.WrapResponseWriter)
+		if !ok {
+			ww = middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		}
+
+		err := fn(ww, r)
+		if err == nil {
+			return
+		}
+		LogEntrySetField(r.Context(), "err", slog.AnyValue(err))
+
+		if ww.Status() != 0 {
+			// the handler already wrote the response
+			return
+		}
+		status := http.StatusInternalServerError
+		if statusFunc != nil {
+			status = statusFunc(err)
+		}
+		http.Error(ww, http.StatusText(status), status)
+	})
+}
+
+// harSensitiveHeaders are the headers whose values RequestToHAR redacts.
+var harSensitiveHeaders = []string{"authorization", "cookie", "proxy-authorization", "set-cookie"}
+
+// RequestToHAR returns the request, with the given body, as the request of an
+// HTTP Archive (HAR) entry, which browser devtools and Postman can import to
+// replay it. It can be logged as a structured value, ie.
+//
+//	httplog.LogEntrySetField(ctx, "har", slog.AnyValue(httplog.RequestToHAR(r, body)))
+//
+// The values of the authorization and cookie headers, and of the cookies, are
+// redacted.
+func RequestToHAR(req *http.Request, body []byte) map[string]any {
+	names := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	headers := []map[string]any{}
+	for _, k := range names {
+		for _, v := range req.Header[k] {
+			if slices.Contains(harSensitiveHeaders, strings.ToLower(k)) {
+				v = "***"
+			}
+			headers = append(headers, map[string]any{"name": k, "value": v})
+		}
+	}
+
+	cookies := []map[string]any{}
+	for _, c := range req.Cookies() {
+		cookies = append(cookies, map[string]any{"name": c.Name, "value": "***"})
+	}
+
+	queryString := []map[string]any{}
+	for k, values := range req.URL.Query() {
+		for _, v := range values {
+			queryString = append(queryString, map[string]any{"name": k, "value": v})
+		}
+	}
+	slices.SortStableFunc(queryString, func(a, b map[string]any) int {
+		return strings.Compare(a["name"].(string), b["name"].(string))
+	})
+
+	url := *req.URL
+	if url.Host == "" {
+		url.Host = req.Host
+	}
+	if url.Scheme == "" {
+		url.Scheme = "http"
+		if req.TLS != nil {
+			url.Scheme = "https"
+		}
+	}
+
+	har := map[string]any{
+		"method":      req.Method,
+		"url":         url.String(),
+		"httpVersion": req.Proto,
+		"cookies":     cookies,
+		"headers":     headers,
+		"queryString": queryString,
+		"headersSize": -1,
+		"bodySize":    len(body),
+	}
+	if len(body) > 0 {
+		har["postData"] = map[string]any{
+			"mimeType": req.Header.Get("Content-Type"),
+			"text":     string(body),
+		}
+	}
+	return har
+}
@@ -2,14 +2,26 @@ package httplog
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -18,14 +30,28 @@ import (
 type Logger struct {
 	*slog.Logger
 	Options Options
+
+	serviceName string
 }
 
 func NewLogger(serviceName string, options ...Options) *Logger {
-	logger := &Logger{}
+	return newLogger(serviceName, nil, options...)
+}
+
+// NewLoggerWithHandler returns a logger which writes through handler, ie. to
+// log through another logging library, in place of the pretty or JSON output
+// of the options. The options are otherwise applied as with NewLogger, and
+// handler is in charge of the levels and the field names.
+func NewLoggerWithHandler(serviceName string, handler slog.Handler, options ...Options) *Logger {
+	return newLogger(serviceName, handler, options...)
+}
+
+func newLogger(serviceName string, handler slog.Handler, options ...Options) *Logger {
+	logger := &Logger{serviceName: serviceName}
 	if len(options) > 0 {
-		logger.Configure(options[0])
+		logger.configure(options[0], handler)
 	} else {
-		logger.Configure(defaultOptions)
+		logger.configure(defaultOptions, handler)
 	}
 
 	slogger := logger.Logger.With(slog.Attr{Key: "service", Value: slog.StringValue(serviceName)})
@@ -46,6 +72,19 @@ func NewLogger(serviceName string, options ...Options) *Logger {
 //
 // NOTE: for simplicity, RequestLogger automatically makes use of the chi RequestID and
 // Recoverer middleware.
+//
+// Loggers may be nested to use different options for a group of routes, ie.
+// verbose logging for /admin. Only the outermost logger buffers the response
+// and writes a response log, using the options of the innermost logger. Fields
+// set on the log entry before reaching the inner logger are dropped. Prefer
+// Handler for the inner logger, as the outer one already sets the request id
+// and recovers from panics.
+//
+// The outer logger wraps the request and response before the inner one is
+// reached, so LogRequestBytes, LogBodyHash, LogStreamStats, HeartbeatInterval,
+// LogBodyResponseHeader, EmitSpanEvents, CaptureSlowStack and Trace are taken
+// from the outer logger's options. An inner logger without Trace keeps logging
+// the trace ids set up by the outer one.
 func RequestLogger(logger *Logger, skipPaths ...[]string) func(next http.Handler) http.Handler {
 	return chi.Chain(
 		middleware.RequestID,
@@ -55,7 +94,20 @@ func RequestLogger(logger *Logger, skipPaths ...[]string) func(next http.Handler
 }
 
 func Handler(logger *Logger, optSkipPaths ...[]string) func(next http.Handler) http.Handler {
-	var f middleware.LogFormatter = &requestLogger{logger.Logger, logger.Options}
+	if logger == nil {
+		slog.Default().Warn("httplog: nil logger, falling back to slog.Default()")
+		logger = &Logger{Logger: slog.Default(), Options: defaultOptions.withDefaults()}
+	}
+
+	f := &requestLogger{Logger: logger.Logger, Options: logger.Options, serviceName: logger.serviceName}
+	if logger.Options.IdempotencyKeyHeader != "" {
+		f.idempotencyKeys = newKeyCache(idempotencyKeysSize, idempotencyKeysTTL)
+	}
+	if logger.Options.LogHandlerSource {
+		f.handlerSources = &handlerSourceCache{}
+	}
+
+	coolDowns := newCoolDownCache()
 
 	skipPaths := map[string]struct{}{}
 	if len(optSkipPaths) > 0 {
@@ -65,7 +117,17 @@ func Handler(logger *Logger, optSkipPaths ...[]string) func(next http.Handler) h
 	}
 
 	return func(next http.Handler) http.Handler {
+		if next == nil {
+			panic("httplog: nil handler")
+		}
+
 		fn := func(w http.ResponseWriter, r *http.Request) {
+			// Count the request once, by the outermost logger
+			if _, nested := r.Context().Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); !nested {
+				inFlight.Add(1)
+				defer inFlight.Add(-1)
+			}
+
 			// Skip the logger if the path is in the skip list
 			if len(skipPaths) > 0 {
 				_, skip := skipPaths[r.URL.Path]
@@ -75,7 +137,27 @@ func Handler(logger *Logger, optSkipPaths ...[]string) func(next http.Handler) h
 				}
 			}
 
-			if rInCooldown(r, &logger.Options) {
+			// Skip the logger for health checkers and other known clients
+			if len(logger.Options.SkipUserAgents) > 0 && skipUserAgent(r.UserAgent(), logger.Options.SkipUserAgents) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if coolDowns.inCooldown(r, &logger.Options) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Nested logger, ie. with different options for a route group: take
+			// over the entry of the outer logger, which keeps buffering the
+			// response and writes the single response log with these options.
+			if parent, ok := r.Context().Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok {
+				inner := *f
+				if inner.Options.Trace == nil {
+					// the trace ids were set up by the outer logger
+					inner.Options.Trace = parent.Options.Trace
+				}
+				parent.override(inner.newLogEntry(r, !logger.Options.Concise && parent.Options.Concise))
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -83,6 +165,12 @@ func Handler(logger *Logger, optSkipPaths ...[]string) func(next http.Handler) h
 			ctx := r.Context()
 			if logger.Options.Trace != nil {
 				traceID := r.Header.Get(logger.Options.Trace.HeaderTrace)
+				if parentTraceID, parentSpanID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+					if traceID == "" {
+						traceID = parentTraceID
+					}
+					ctx = context.WithValue(ctx, _contextKeyParentSpan, parentSpanID)
+				}
 				if traceID == "" {
 					traceID = newID()
 				}
@@ -93,22 +181,87 @@ func Handler(logger *Logger, optSkipPaths ...[]string) func(next http.Handler) h
 
 			r = r.WithContext(ctx)
 
-			entry := f.NewLogEntry(r)
+			entry := f.newLogEntry(r, !logger.Options.Concise || logger.Options.LogRequestOnly)
+			entry.requestSize = r.ContentLength
+			if logger.Options.LogRequestBytes && r.Body != nil {
+				entry.requestBody = &countingReader{ReadCloser: r.Body}
+				r.Body = entry.requestBody
+			}
+			if logger.Options.LogBodyHash && r.Body != nil && r.Body != http.NoBody {
+				entry.requestBodyHash = newHashingReader(r.Body)
+				r.Body = entry.requestBodyHash
+			}
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
+			var rw http.ResponseWriter = ww
+			if logger.Options.LogStreamStats {
+				entry.streamStats = &streamStatsWriter{WrapResponseWriter: ww}
+				rw = entry.streamStats
+			}
+
+			// HEAD responses have no body worth buffering
 			buf := newLimitBuffer(512)
-			ww.Tee(buf)
+			if r.Method != http.MethodHead {
+				ww.Tee(buf)
+			}
+
+			if logger.Options.HeartbeatInterval > 0 {
+				hb := startHeartbeat(r.Context(), entry.Logger, rw, logger.Options.HeartbeatInterval)
+				defer hb.Stop()
+				rw = hb
+			}
 
-			t1 := time.Now()
+			if logger.Options.LogBodyResponseHeader != "" {
+				entry.bodyMarker = &bodyMarkerWriter{ResponseWriter: rw, header: logger.Options.LogBodyResponseHeader}
+				rw = entry.bodyMarker
+			}
+
+			if logger.Options.Trace != nil && logger.Options.EmitSpanEvents {
+				spanStart := logger.Options.now()
+				logSpanEvent(r.Context(), entry.accessLog(), "start", spanName(r, &logger.Options), 0)
+				defer func() {
+					// named after the route, which is only known by now
+					logSpanEvent(r.Context(), entry.accessLog(), "end", spanName(r, &logger.Options), logger.Options.now().Sub(spanStart))
+				}()
+			}
+
+			var sw *stackWatcher
+			if logger.Options.CaptureSlowStack {
+				sw = watchStack(r.Context())
+			}
+
+			t1 := logger.Options.now()
 			defer func() {
+				elapsed := logger.Options.now().Sub(t1)
+				if sw != nil {
+					entry.deadlineStack = sw.Stop()
+				}
+				if entry.bodyMarker != nil {
+					// the handler may not have written anything, in which case
+					// net/http writes the header once we return
+					entry.bodyMarker.check()
+				}
+				if entry.Options.LogRequestOnly && entry.panicStack == nil {
+					return
+				}
+				// the status set by the handler, if any, is the one logged
+				status := ww.Status()
+				if entry.status != 0 {
+					status = entry.status
+				}
+				if entry.Options.Sampler != nil && !entry.Options.Sampler.Sample(r, status, elapsed) {
+					return
+				}
+
 				var respBody []byte
-				if ww.Status() >= 400 {
+				tooLarge := entry.Options.BodyLogSizeLimit > 0 && ww.BytesWritten() > entry.Options.BodyLogSizeLimit
+				if (status >= 400 || entry.bodyMarker != nil) && !tooLarge {
 					respBody, _ = io.ReadAll(buf)
 				}
-				entry.Write(ww.Status(), ww.BytesWritten(), ww.Header(), time.Since(t1), respBody)
+				entry.Write(ww.Status(), ww.BytesWritten(), ww.Header(), elapsed, respBody)
 			}()
 
-			next.ServeHTTP(ww, middleware.WithLogEntry(r, entry))
+			next.ServeHTTP(rw, middleware.WithLogEntry(r, entry))
 		}
 		return http.HandlerFunc(fn)
 	}
@@ -117,39 +270,171 @@ func Handler(logger *Logger, optSkipPaths ...[]string) func(next http.Handler) h
 type requestLogger struct {
 	Logger  *slog.Logger
 	Options Options
+
+	idempotencyKeys *keyCache
+	handlerSources  *handlerSourceCache
+	serviceName     string
 }
 
 func (l *requestLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
-	entry := &RequestLoggerEntry{l.Logger, l.Options, ""}
+	return l.newLogEntry(r, !l.Options.Concise)
+}
+
+func (l *requestLogger) newLogEntry(r *http.Request, logRequest bool) *RequestLoggerEntry {
+	entry := &RequestLoggerEntry{Logger: l.Logger, Options: l.Options, ctx: r.Context(), request: r, method: r.Method, serviceName: l.serviceName, received: l.Options.now(), handlerSources: l.handlerSources}
 	msg := fmt.Sprintf("Request: %s %s", r.Method, r.URL.Path)
+	if l.Options.OmitMessage {
+		msg = ""
+	}
+
+	var fields []any
 
-	logger := l.Logger
+	if trace := l.Options.Trace; trace != nil {
+		if traceID, ok := r.Context().Value(_contextKeyTrace).(string); ok {
+			fields = append(fields, slog.Attr{Key: trace.LogFieldTrace, Value: slog.StringValue(traceID)})
+		}
+		if spanID, ok := r.Context().Value(_contextKeySpan).(string); ok {
+			fields = append(fields, slog.Attr{Key: trace.LogFieldSpan, Value: slog.StringValue(spanID)})
+		}
+		if parentSpanID, ok := r.Context().Value(_contextKeyParentSpan).(string); ok {
+			fields = append(fields, slog.Attr{Key: trace.LogFieldParentSpan, Value: slog.StringValue(parentSpanID)})
+		}
+	}
 
-	if traceID, ok := r.Context().Value(_contextKeyTrace).(string); ok {
-		logger = logger.With(slog.Attr{Key: l.Options.Trace.LogFieldTrace, Value: slog.StringValue(traceID)})
+	requestFields := requestLogFields(r, l.Options, l.Options.RequestHeaders && !l.Options.VerboseOnError)
+	if l.Options.RequestHeaders && l.Options.VerboseOnError && len(r.Header) > 0 {
+		// only logged along with failed responses
+		entry.requestHeader = headerLogField(r.Header, l.Options)
+	}
+	if l.Options.LogCachingHeaders {
+		// logged along with the response caching headers
+		entry.cachingHeader = cachingLogField(r.Header, requestCachingHeaders)
+	}
+	if l.Options.LogContentNegotiation {
+		// logged along with the negotiated content type
+		entry.accept = r.Header.Get("Accept")
 	}
-	if spanID, ok := r.Context().Value(_contextKeySpan).(string); ok {
-		logger = logger.With(slog.Attr{Key: l.Options.Trace.LogFieldSpan, Value: slog.StringValue(spanID)})
+	if l.idempotencyKeys != nil {
+		if key := r.Header.Get(l.Options.IdempotencyKeyHeader); key != "" {
+			requestFields = appendToGroup(requestFields,
+				slog.Attr{Key: "idempotencyKey", Value: slog.StringValue(key)},
+				slog.Attr{Key: "retry", Value: slog.BoolValue(l.idempotencyKeys.seen(key))})
+		}
+	}
+	if l.Options.GroupHTTPAttrs {
+		// keep the request fields aside, they're logged along with the
+		// response fields under a single group
+		requestFields.Key = "request"
+		entry.requestAttr = requestFields
+	} else {
+		fields = append(fields, requestFields)
+	}
+	entry.Logger = l.Logger.With(fields...)
+	entry.baseLogger = entry.Logger
+	entry.fieldsSize = l.loggerFieldsSize() + fieldsSize(fields)
+	entry.baseFieldsSize = entry.fieldsSize
+	if l.Options.AccessLogger != nil {
+		entry.accessLogger = l.Options.AccessLogger.With(fields...)
+		entry.baseAccessLogger = entry.accessLogger
 	}
 
-	entry.Logger = logger.With(requestLogFields(r, l.Options, l.Options.RequestHeaders))
+	if l.Options.TimeoutHeader != "" {
+		entry.clientTimeout, _ = parseTimeout(r.Header.Get(l.Options.TimeoutHeader))
+	}
 
-	if !l.Options.Concise {
-		entry.Logger.Info(msg)
+	if logRequest {
+		var attrs []any
+		if l.Options.GroupHTTPAttrs {
+			attrs = append(attrs, slog.Group("http", requestFields))
+		}
+		if l.Options.LogID {
+			attrs = append(attrs, slog.Attr{Key: "logID", Value: slog.StringValue(newID())})
+		}
+		entry.accessLog().Log(r.Context(), l.Options.atLeastMinLevel(slog.LevelInfo), msg, attrs...)
 	}
 	return entry
 }
 
 type RequestLoggerEntry struct {
-	Logger  *slog.Logger
-	Options Options
-	msg     string
+	Logger      *slog.Logger
+	Options     Options
+	baseLogger  *slog.Logger
+	ctx         context.Context
+	request     *http.Request
+	msg         string
+	method      string
+	requestAttr slog.Attr
+	streamStats *streamStatsWriter
+	sensitive   bool
+	requestBody *countingReader
+	requestSize int64
+
+	requestBodyHash *hashingReader
+	bodyMarker      *bodyMarkerWriter
+
+	// estimated size of the fields attached to Logger, for MaxLogSize
+	fieldsSize     int
+	baseFieldsSize int
+
+	handlerSources *handlerSourceCache
+
+	deadlineStack []byte
+	panicStack    []byte
+	serviceName   string
+	clientTimeout time.Duration
+	received      time.Time
+	requestHeader []slog.Attr
+	cachingHeader []slog.Attr
+	accept        string
+	upstream      string
+	operation     string
+	status        int
+	tags          []slog.Attr
+
+	validationErrors map[string]string
+
+	timingsMu sync.Mutex
+	timings   []timing
+
+	accessLogger     *slog.Logger
+	baseAccessLogger *slog.Logger
+}
+
+// override replaces the logger and options of the entry with those of the
+// entry of a nested logger.
+func (l *RequestLoggerEntry) override(nested *RequestLoggerEntry) {
+	l.Logger = nested.Logger
+	l.baseLogger = nested.baseLogger
+	l.fieldsSize = nested.fieldsSize
+	l.baseFieldsSize = nested.baseFieldsSize
+	l.accessLogger = nested.accessLogger
+	l.baseAccessLogger = nested.baseAccessLogger
+	l.Options = nested.Options
+	l.requestAttr = nested.requestAttr
+	l.serviceName = nested.serviceName
+	l.clientTimeout = nested.clientTimeout
+	l.requestHeader = nested.requestHeader
+	l.cachingHeader = nested.cachingHeader
+	l.accept = nested.accept
+	l.handlerSources = nested.handlerSources
 }
 
 func (l *RequestLoggerEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
-	msg := fmt.Sprintf("Response: %d %s", status, statusLabel(status))
+	wireStatus := status
+	if l.status != 0 {
+		status = l.status
+	}
+
+	label := statusLabel(status)
+	if l.Options.StatusLabelFunc != nil {
+		label = l.Options.StatusLabelFunc(status)
+	}
+	msg := fmt.Sprintf("Response: %d %s", status, label)
 	if l.msg != "" {
-		msg = fmt.Sprintf("%s - %s", msg, l.msg)
+		msg = fmt.Sprintf("%s - %s", msg, redactPatterns([]byte(l.msg), l.Options.RedactPatterns))
+	}
+	if l.Options.OmitMessage {
+		msg = ""
 	}
 
 	responseLog := []any{
@@ -157,65 +442,408 @@ func (l *RequestLoggerEntry) Write(status, bytes int, header http.Header, elapse
 		slog.Attr{Key: "bytes", Value: slog.IntValue(bytes)},
 		slog.Attr{Key: "elapsed", Value: slog.Float64Value(float64(elapsed.Nanoseconds()) / 1000000.0)}, // in milliseconds
 	}
+	if wireStatus != status {
+		responseLog = append(responseLog, slog.Attr{Key: "wireStatus", Value: slog.IntValue(wireStatus)})
+	}
+	if l.clientTimeout > 0 {
+		responseLog = append(responseLog, slog.Attr{Key: "clientTimeout", Value: slog.Float64Value(float64(l.clientTimeout.Nanoseconds()) / 1000000.0)}) // in milliseconds
+	}
+	if l.Options.StatusClassFieldName != "" {
+		responseLog = append(responseLog, slog.Attr{Key: l.Options.StatusClassFieldName, Value: slog.StringValue(statusClass(status))})
+	}
 
-	if !l.Options.Concise {
+	if l.requestBody != nil {
+		responseLog = append(responseLog, slog.Attr{Key: "requestBytes", Value: slog.Int64Value(l.requestBody.n)})
+	}
+
+	if l.Options.LogSizeRatio {
+		requestSize := l.requestSize
+		if l.requestBody != nil {
+			requestSize = l.requestBody.n
+		}
+		if requestSize > 0 {
+			responseLog = append(responseLog, slog.Attr{Key: "sizeRatio", Value: slog.Float64Value(float64(bytes) / float64(requestSize))})
+		}
+	}
+
+	if l.requestBodyHash != nil {
+		if sum := l.requestBodyHash.Sum(); sum != "" {
+			responseLog = append(responseLog, slog.Attr{Key: "requestBodyHash", Value: slog.StringValue(sum)})
+		}
+	}
+
+	if l.streamStats != nil {
+		responseLog = append(responseLog,
+			slog.Attr{Key: "writes", Value: slog.IntValue(l.streamStats.writes)},
+			slog.Attr{Key: "flushes", Value: slog.IntValue(l.streamStats.flushes)})
+	}
+
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if location := header.Get("Location"); location != "" {
+			responseLog = append(responseLog, slog.Group("redirect",
+				slog.Attr{Key: "location", Value: slog.StringValue(redactLocation(location))}))
+		}
+	}
+
+	if status == http.StatusMethodNotAllowed {
+		if allow := header.Get("Allow"); allow != "" {
+			responseLog = append(responseLog, slog.Attr{Key: "allowedMethods", Value: slog.StringValue(allow)})
+		}
+	}
+
+	if l.Options.LogCachingHeaders {
+		if caching := append(slices.Clone(l.cachingHeader), cachingLogField(header, responseCachingHeaders)...); len(caching) > 0 {
+			responseLog = append(responseLog, slog.Group("caching", attrsToAnys(caching)...))
+		}
+	}
+
+	if timings := l.timingsLogField(); len(timings) > 0 {
+		responseLog = append(responseLog, slog.Group("timings", timings...))
+	}
+
+	if l.Options.LogContentNegotiation {
+		negotiation := []any{}
+		if l.accept != "" {
+			negotiation = append(negotiation, slog.Attr{Key: "accept", Value: slog.StringValue(l.accept)})
+		}
+		if contentType := header.Get("Content-Type"); contentType != "" {
+			negotiation = append(negotiation, slog.Attr{Key: "contentType", Value: slog.StringValue(contentType)})
+		}
+		if len(negotiation) > 0 {
+			responseLog = append(responseLog, slog.Group("negotiation", negotiation...))
+		}
+	}
+
+	if len(l.validationErrors) > 0 {
+		fields := make([]slog.Attr, 0, len(l.validationErrors))
+		for field, msg := range l.validationErrors {
+			msg = string(redactPatterns([]byte(msg), l.Options.RedactPatterns))
+			fields = append(fields, slog.Attr{Key: field, Value: slog.StringValue(msg)})
+		}
+		slices.SortFunc(fields, func(a, b slog.Attr) int { return strings.Compare(a.Key, b.Key) })
+		responseLog = append(responseLog, slog.Group("validationErrors", attrsToAnys(fields)...))
+	}
+
+	if status >= 400 && len(l.requestHeader) > 0 && !l.sensitive {
+		responseLog = append(responseLog, slog.Group("requestHeader", attrsToAnys(l.requestHeader)...))
+	}
+
+	if !l.Options.Concise && !l.sensitive {
 		// Include response header, as well for error status codes (>400) we include
 		// the response body so we may inspect the log message sent back to the client.
-		if status >= 400 {
+		logBody := status >= 400
+		if len(l.Options.LogBodyRoutes) > 0 {
+			logBody = logBody && l.matchRoutes(l.Options.LogBodyRoutes)
+		}
+		if l.bodyMarker != nil {
+			logBody = l.bodyMarker.marked
+		}
+		if logBody && l.method != http.MethodHead {
 			body, _ := extra.([]byte)
-			responseLog = append(responseLog, slog.Attr{Key: "body", Value: slog.StringValue(string(body))})
+			if len(body) < bytes {
+				// the body was cut short, possibly in the middle of a rune
+				body = trimPartialRune(body)
+			}
+			loggedLen := len(body)
+			rawBody := body
+			body = redactPatterns(body, l.Options.RedactPatterns)
+			bodyAttr := slog.Attr{Key: "body", Value: slog.StringValue(string(body))}
+			if l.Options.LogBodyAsJSON {
+				if v, ok := jsonBodyValue(body); ok {
+					bodyAttr.Value = v
+				}
+			}
+			if l.Options.SummarizeInvalidJSON && loggedLen == bytes && strings.Contains(header.Get("Content-Type"), "json") {
+				if v, ok := jsonErrorValue(rawBody, l.Options.RedactPatterns); ok {
+					bodyAttr = slog.Attr{Key: "bodyJSONError", Value: v}
+				}
+			}
+			if l.Options.LogBodyBase64 && !utf8.Valid(body) {
+				bodyAttr = slog.Attr{Key: "bodyBase64", Value: slog.StringValue(base64.StdEncoding.EncodeToString(body))}
+			}
+			if l.Options.BodyHexPrefixLen > 0 && !utf8.Valid(body) {
+				prefix := body[:min(len(body), l.Options.BodyHexPrefixLen)]
+				bodyAttr = slog.Attr{Key: "bodyHexPrefix", Value: slog.StringValue(hex.EncodeToString(prefix))}
+				loggedLen = len(prefix)
+			}
+			if l.Options.BodyLogSizeLimit > 0 && bytes > l.Options.BodyLogSizeLimit {
+				bodyAttr = slog.Attr{Key: "bodyTooLarge", Value: slog.BoolValue(true)}
+				loggedLen = 0
+				responseLog = append(responseLog, slog.Attr{Key: "bodySize", Value: slog.IntValue(bytes)})
+			}
+			responseLog = append(responseLog, bodyAttr)
+			if l.Options.OnBodyTruncated != nil && loggedLen < bytes {
+				l.Options.OnBodyTruncated(l.request, "response", bytes, loggedLen)
+			}
 		}
-		if l.Options.ResponseHeaders && len(header) > 0 {
+		if (!l.Options.VerboseOnError || status >= 400) && l.Options.ResponseHeaders && len(header) > 0 {
 			responseLog = append(responseLog, slog.Group("header", attrsToAnys(headerLogField(header, l.Options))...))
 		}
 	}
 
-	l.Logger.With(slog.Group("httpResponse", responseLog...)).Log(context.Background(), statusLevel(status), msg)
+	level := statusLevel(status)
+	if l.method == http.MethodOptions {
+		// successful CORS preflights are just noise, failing ones are worth a look
+		if status > 0 && status < 400 {
+			level = slog.LevelDebug
+		} else if level < slog.LevelWarn {
+			level = slog.LevelWarn
+		}
+	}
+
+	if len(l.validationErrors) > 0 && level < slog.LevelWarn {
+		level = slog.LevelWarn
+	}
+	level = l.Options.atLeastMinLevel(level)
+
+	ctx := l.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	responseGroup := func(responseLog []any) slog.Attr {
+		switch {
+		case l.Options.GroupHTTPAttrs:
+			return slog.Group("http", l.requestAttr, slog.Group("response", responseLog...))
+		case l.Options.NestHTTPObjects:
+			return slog.Group("response", responseLog...)
+		}
+		return slog.Group("httpResponse", responseLog...)
+	}
+	attrs := []slog.Attr{responseGroup(responseLog)}
+
+	if l.Options.LogID {
+		attrs = append(attrs, slog.Attr{Key: "logID", Value: slog.StringValue(newID())})
+	}
+
+	if l.Options.LogHandlerSource && l.handlerSources != nil {
+		if source, ok := l.handlerSources.logField(ctx, l.method); ok {
+			attrs = append(attrs, source)
+		}
+	}
+
+	if len(l.tags) > 0 {
+		attrs = append(attrs, slog.Group("requestTags", attrsToAnys(l.tags)...))
+	}
+
+	if l.operation != "" {
+		attrs = append(attrs, slog.Attr{Key: "operation", Value: slog.StringValue(l.operation)})
+	}
+
+	if l.upstream != "" {
+		attrs = append(attrs, slog.Group("upstream", slog.Attr{Key: "address", Value: slog.StringValue(l.upstream)}))
+	}
+
+	if l.Options.ReceivedTimeFieldName != "" && !l.received.IsZero() {
+		attrs = append(attrs, slog.Attr{Key: l.Options.ReceivedTimeFieldName, Value: slog.StringValue(l.received.Format(l.Options.TimeFieldFormat))})
+	}
+
+	if len(l.deadlineStack) > 0 {
+		attrs = append(attrs, slog.Attr{Key: "deadlineStacktrace", Value: stackValue(l.deadlineStack, l.Options.StackTraceFormat)})
+	}
+
+	if l.Options.LogRuntimeStatsOnError && (status >= 500 || l.msg != "") {
+		attrs = append(attrs, runtimeStatsLogField())
+	}
+
+	if l.Options.GCPErrorReporting && l.panicStack != nil {
+		// Error Reporting picks up logs of this type, given the message is
+		// formatted the way the Go runtime reports panics
+		msg = fmt.Sprintf("panic: %s\n\n%s", redactPatterns([]byte(l.msg), l.Options.RedactPatterns), l.panicStack)
+		attrs = append(attrs,
+			slog.Attr{Key: "@type", Value: slog.StringValue("type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent")},
+			slog.Group("serviceContext", slog.Attr{Key: "service", Value: slog.StringValue(l.serviceName)}))
+	}
+
+	if l.Options.MaxLogSize > 0 {
+		// only the response fields may be dropped, they get whatever room is
+		// left by the message and the other fields of the record
+		maxSize := l.Options.MaxLogSize - len(msg) - l.fieldsSize
+		for _, a := range attrs[1:] {
+			maxSize -= attrSize(a)
+		}
+		if l.Options.GroupHTTPAttrs {
+			maxSize -= attrSize(l.requestAttr)
+		}
+		attrs[0] = responseGroup(fitLogSize(responseLog, maxSize))
+	}
+
+	if l.Options.OnLog != nil {
+		l.Options.OnLog(ctx, level, attrs)
+	}
+
+	l.accessLog().LogAttrs(ctx, level, msg, attrs...)
+}
+
+// matchRoutes reports whether the chi route pattern of the request, or its
+// path if it wasn't routed with chi, is one of the routes. Routes ending with
+// "*" match any route or path with the same prefix.
+func (l *RequestLoggerEntry) matchRoutes(routes []string) bool {
+	route := ""
+	if l.ctx != nil {
+		if rctx := chi.RouteContext(l.ctx); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+	}
+	if route == "" && l.request != nil {
+		route = l.request.URL.Path
+	}
+
+	for _, r := range routes {
+		if prefix, ok := strings.CutSuffix(r, "*"); ok && strings.HasPrefix(route, prefix) {
+			return true
+		}
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// with attaches the fields to the logger of the entry, and to its access
+// logger if any.
+func (l *RequestLoggerEntry) with(fields ...any) {
+	l.Logger = l.Logger.With(fields...)
+	l.fieldsSize += fieldsSize(fields)
+	if l.accessLogger != nil {
+		l.accessLogger = l.accessLogger.With(fields...)
+	}
+}
+
+// loggerFieldsSize estimates the size of the fields attached to the logger by
+// NewLogger. Fields attached to the slog.Logger beforehand aren't known.
+func (l *requestLogger) loggerFieldsSize() int {
+	size := attrSize(slog.Attr{Key: "service", Value: slog.StringValue(l.serviceName)})
+	if !l.Options.Concise && len(l.Options.Tags) > 0 {
+		size += len("tags") + 4
+		for k, v := range l.Options.Tags {
+			size += attrSize(slog.Attr{Key: k, Value: slog.StringValue(v)})
+		}
+	}
+	return size
+}
+
+// accessLog returns the logger for the request and response logs.
+func (l *RequestLoggerEntry) accessLog() *slog.Logger {
+	if l.accessLogger != nil {
+		return l.accessLogger
+	}
+	return l.Logger
+}
+
+// inFlight is the number of requests being served.
+var inFlight atomic.Int64
+
+// InFlight returns the number of requests currently being served by handlers
+// wrapped with the request logger, a cheap signal of the load for health
+// checks.
+func InFlight() int64 {
+	return inFlight.Load()
+}
+
+// panicCount is the number of panics recovered from handlers.
+var panicCount atomic.Uint64
+
+// PanicCount returns the number of panics recovered from handlers since the
+// program started, a cheap signal for health checks to detect handlers which
+// keep crashing.
+func PanicCount() uint64 {
+	return panicCount.Load()
 }
 
 func (l *RequestLoggerEntry) Panic(v interface{}, stack []byte) {
-	stacktrace := "#"
+	panicCount.Add(1)
+
+	stacktrace := slog.StringValue("#")
 	if l.Options.JSON {
-		stacktrace = string(stack)
+		stacktrace = stackValue(stack, l.Options.StackTraceFormat)
 	}
-	l.Logger = l.Logger.With(
+	l.with(
 		slog.Attr{
 			Key:   "stacktrace",
-			Value: slog.StringValue(stacktrace)},
+			Value: stacktrace},
 		slog.Attr{
 			Key:   "panic",
-			Value: slog.StringValue(fmt.Sprintf("%+v", v)),
+			Value: slog.StringValue(string(redactPatterns([]byte(fmt.Sprintf("%+v", v)), l.Options.RedactPatterns))),
 		})
 
 	l.msg = fmt.Sprintf("%+v", v)
+	l.panicStack = stack
 
 	if !l.Options.JSON {
 		middleware.PrintPrettyStack(v)
 	}
 }
 
-var coolDownMu sync.RWMutex
-var coolDowns = map[string]time.Time{}
+// coolDownCache tracks when the routes, or keys, quieted down were last logged.
+// Each Handler has its own, so that separate loggers don't quiet each other.
+type coolDownCache struct {
+	mu sync.RWMutex
+	m  map[string]time.Time
+}
 
-func rInCooldown(r *http.Request, options *Options) bool {
-	routePath := r.URL.EscapedPath()
-	if routePath == "" {
-		routePath = "/"
+// maxCoolDowns bounds the number of cooldown keys kept around, which matters
+// when keys are derived from unbounded values like client IPs.
+const maxCoolDowns = 10000
+
+func newCoolDownCache() *coolDownCache {
+	return &coolDownCache{m: map[string]time.Time{}}
+}
+
+func (c *coolDownCache) inCooldown(r *http.Request, options *Options) bool {
+	var key string
+	if options.QuietDownKey != nil {
+		key = options.QuietDownKey(r)
+	} else {
+		routePath := r.URL.EscapedPath()
+		if routePath == "" {
+			routePath = "/"
+		}
+		if !inArray(options.QuietDownRoutes, routePath) {
+			return false
+		}
+		key = routePath
 	}
-	if !inArray(options.QuietDownRoutes, routePath) {
+	if key == "" {
 		return false
 	}
-	coolDownMu.RLock()
-	coolDownTime, ok := coolDowns[routePath]
-	coolDownMu.RUnlock()
-	if ok {
-		if time.Since(coolDownTime) < options.QuietDownPeriod {
+
+	now := options.now()
+	c.mu.RLock()
+	lastLogged, ok := c.m[key]
+	c.mu.RUnlock()
+	if ok && now.Sub(lastLogged) < options.QuietDownPeriod {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.m) >= maxCoolDowns {
+		for k, t := range c.m {
+			if now.Sub(t) >= options.QuietDownPeriod {
+				delete(c.m, k)
+			}
+		}
+	}
+	// Still full of keys in their cooldown: drop some arbitrary ones, rather
+	// than growing without bounds, and make room for the next keys as well.
+	for k := range c.m {
+		if len(c.m) < maxCoolDowns*9/10 {
+			break
+		}
+		delete(c.m, k)
+	}
+	c.m[key] = now
+	return false
+}
+
+func skipUserAgent(userAgent string, skip []string) bool {
+	for _, s := range skip {
+		if strings.Contains(userAgent, s) {
 			return true
 		}
 	}
-	coolDownMu.Lock()
-	defer coolDownMu.Unlock()
-	coolDowns[routePath] = time.Now().Add(options.QuietDownPeriod)
 	return false
 }
 
@@ -235,10 +863,20 @@ func requestLogFields(r *http.Request, options Options, requestHeaders bool) slo
 	}
 	requestURL := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
 
+	path := r.URL.Path
+	if options.NormalizePath != nil {
+		path = options.NormalizePath(path)
+	}
+
+	method := r.Method
+	if options.NormalizeMethod {
+		method = normalizeMethod(method)
+	}
+
 	requestFields := []any{
 		slog.Attr{Key: "url", Value: slog.StringValue(requestURL)},
-		slog.Attr{Key: "method", Value: slog.StringValue(r.Method)},
-		slog.Attr{Key: "path", Value: slog.StringValue(r.URL.Path)},
+		slog.Attr{Key: "method", Value: slog.StringValue(method)},
+		slog.Attr{Key: "path", Value: slog.StringValue(path)},
 		slog.Attr{Key: "remoteIP", Value: slog.StringValue(r.RemoteAddr)},
 		slog.Attr{Key: "proto", Value: slog.StringValue(r.Proto)},
 	}
@@ -246,8 +884,56 @@ func requestLogFields(r *http.Request, options Options, requestHeaders bool) slo
 		requestFields = append(requestFields, slog.Attr{Key: "requestID", Value: slog.StringValue(reqID)})
 	}
 
-	if !options.RequestHeaders {
-		return slog.Group("httpRequest", requestFields...)
+	if options.LogInFlight {
+		requestFields = append(requestFields, slog.Attr{Key: "inFlight", Value: slog.Int64Value(inFlight.Load())})
+	}
+
+	if options.QueueDelayHeader != "" {
+		if start, ok := parseRequestStart(r.Header.Get(options.QueueDelayHeader)); ok {
+			delay := max(options.now().Sub(start), 0)
+			requestFields = append(requestFields, slog.Attr{Key: "queueDelay", Value: slog.Float64Value(float64(delay.Nanoseconds()) / 1000000.0)}) // in milliseconds
+		}
+	}
+
+	if (options.LogTLSInfo || options.LogClientCert || options.LogTLSHandshake) && r.TLS != nil {
+		tlsFields := tlsLogField(r.TLS, options)
+		if options.LogTLSHandshake {
+			if d, ok := tlsHandshakeDuration(r.Context()); ok {
+				tlsFields = append(tlsFields, slog.Attr{Key: "handshake", Value: slog.Float64Value(float64(d.Nanoseconds()) / 1000000.0)}) // in milliseconds
+			}
+		}
+		if len(tlsFields) > 0 {
+			requestFields = append(requestFields, slog.Group("tls", attrsToAnys(tlsFields)...))
+		}
+	}
+
+	if options.LogBaggage {
+		if baggage := baggageLogField(r.Header.Get("Baggage"), options); len(baggage) > 0 {
+			requestFields = append(requestFields, slog.Group("baggage", attrsToAnys(baggage)...))
+		}
+	}
+
+	if options.LogFingerprint {
+		requestFields = append(requestFields, slog.Attr{Key: "fingerprint", Value: slog.StringValue(fingerprint(method, path, r.RemoteAddr, options.HeaderDigestSalt))})
+	}
+
+	if options.LogHeaderDigest && len(r.Header) > 0 {
+		requestFields = append(requestFields, slog.Attr{Key: "headerDigest", Value: slog.StringValue(headerDigest(r.Header, options.HeaderDigestSalt))})
+	}
+
+	if options.LogFetchMetadata {
+		if security := fetchMetadataLogField(r.Header); len(security) > 0 {
+			requestFields = append(requestFields, slog.Group("security", attrsToAnys(security)...))
+		}
+	}
+
+	groupKey := "httpRequest"
+	if options.NestHTTPObjects {
+		groupKey = "request"
+	}
+
+	if !requestHeaders {
+		return slog.Group(groupKey, requestFields...)
 	}
 
 	// include request headers
@@ -260,13 +946,46 @@ func requestLogFields(r *http.Request, options Options, requestHeaders bool) slo
 			})
 	}
 
-	return slog.Group("httpRequest", requestFields...)
+	return slog.Group(groupKey, requestFields...)
+}
+
+// standardMethods are the methods defined by RFC 9110 and RFC 5789.
+var standardMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// normalizeMethod returns the method in upper case, or "OTHER" for methods
+// which aren't standard, to keep the cardinality of the logged methods low.
+func normalizeMethod(method string) string {
+	method = strings.ToUpper(method)
+	if !inArray(standardMethods, method) {
+		return "OTHER"
+	}
+	return method
+}
+
+// DefaultHeaders are common header values, keyed by the lower case header
+// name, which aren't logged when Options.SkipDefaultHeaders is set.
+var DefaultHeaders = map[string][]string{
+	"accept":                    {"*/*"},
+	"accept-encoding":           {"gzip", "gzip, deflate", "gzip, deflate, br", "gzip, deflate, br, zstd"},
+	"cache-control":             {"no-cache", "max-age=0"},
+	"connection":                {"keep-alive", "close"},
+	"pragma":                    {"no-cache"},
+	"upgrade-insecure-requests": {"1"},
+	"sec-fetch-user":            {"?1"},
+	"sec-ch-ua-mobile":          {"?0"},
+	"x-content-type-options":    {"nosniff"},
 }
 
 func headerLogField(header http.Header, options Options) []slog.Attr {
 	headerField := []slog.Attr{}
 	for k, v := range header {
 		k = strings.ToLower(k)
+		if options.SkipDefaultHeaders && len(v) == 1 && inArray(DefaultHeaders[k], v[0]) {
+			continue
+		}
 		switch {
 		case len(v) == 0:
 			continue
@@ -296,6 +1015,181 @@ func headerLogField(header http.Header, options Options) []slog.Attr {
 	return headerField
 }
 
+// headerDigestSalt is the salt of the header digests when none is set.
+var headerDigestSalt = newID()
+
+// headerDigest returns the first 16 hex digits of a salted hash of the
+// headers, sorted by name, to fingerprint clients without logging headers.
+func headerDigest(header http.Header, salt string) string {
+	if salt == "" {
+		salt = headerDigestSalt
+	}
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	mac := hmac.New(sha256.New, []byte(salt))
+	for _, k := range keys {
+		fmt.Fprintf(mac, "%s: %s\n", strings.ToLower(k), strings.Join(header[k], ", "))
+	}
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// fingerprint returns the first 16 hex digits of a salted hash of the method,
+// path and client IP of the request, to group similar requests without
+// logging the IP.
+func fingerprint(method, path, remoteAddr, salt string) string {
+	if salt == "" {
+		salt = headerDigestSalt
+	}
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+
+	mac := hmac.New(sha256.New, []byte(salt))
+	fmt.Fprintf(mac, "%s %s %s", method, path, ip)
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// tlsLogField returns the details of the TLS connection state worth logging.
+func tlsLogField(state *tls.ConnectionState, options Options) []slog.Attr {
+	fields := []slog.Attr{}
+	if options.LogTLSInfo && state.ServerName != "" {
+		fields = append(fields, slog.Attr{Key: "serverName", Value: slog.StringValue(state.ServerName)})
+	}
+	if options.LogTLSInfo && state.NegotiatedProtocol != "" {
+		fields = append(fields, slog.Attr{Key: "alpn", Value: slog.StringValue(state.NegotiatedProtocol)})
+	}
+	if options.LogClientCert && len(state.PeerCertificates) > 0 {
+		fields = append(fields, slog.Attr{Key: "clientSubject", Value: slog.StringValue(state.PeerCertificates[0].Subject.String())})
+	}
+	return fields
+}
+
+// maxLocationLen is the longest redirect location logged.
+const maxLocationLen = 512
+
+// redactLocation hides the values of token-like query params in a redirect
+// location, such as OAuth access tokens, and truncates it if too long.
+func redactLocation(location string) string {
+	if u, err := url.Parse(location); err == nil && u.RawQuery != "" {
+		query := u.Query()
+		redacted := false
+		for k := range query {
+			if strings.Contains(strings.ToLower(k), "token") {
+				query.Set(k, "***")
+				redacted = true
+			}
+		}
+		if redacted {
+			u.RawQuery = query.Encode()
+			location = u.String()
+		}
+	}
+	if len(location) > maxLocationLen {
+		location = location[:maxLocationLen] + "..."
+	}
+	return location
+}
+
+// runtimeStatsLogField returns a snapshot of the goroutine count and memory
+// usage. Reading the memory stats briefly stops the world, so this is only
+// done for failed requests.
+func runtimeStatsLogField() slog.Attr {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return slog.Group("runtime",
+		slog.Attr{Key: "goroutines", Value: slog.IntValue(runtime.NumGoroutine())},
+		slog.Attr{Key: "alloc", Value: slog.Uint64Value(m.Alloc)},
+		slog.Attr{Key: "heapInuse", Value: slog.Uint64Value(m.HeapInuse)},
+	)
+}
+
+// maxBaggageEntries is the maximum number of baggage entries logged.
+const maxBaggageEntries = 16
+
+// baggageLogField parses the entries of a W3C baggage header, ie.
+// "tenant=acme,flag=beta;ttl=60", leaving out the entry properties.
+func baggageLogField(baggage string, options Options) []slog.Attr {
+	fields := []slog.Attr{}
+	if baggage == "" {
+		return fields
+	}
+	for _, member := range strings.Split(baggage, ",") {
+		if len(fields) == maxBaggageEntries {
+			break
+		}
+		member, _, _ = strings.Cut(member, ";")
+		k, v, ok := strings.Cut(member, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(strings.TrimSpace(v)); err == nil {
+			v = unescaped
+		}
+		v = string(redactPatterns([]byte(v), options.RedactPatterns))
+		fields = append(fields, slog.Attr{Key: k, Value: slog.StringValue(v)})
+	}
+	return fields
+}
+
+// fetchMetadataLogField returns the origin related request headers, which
+// are the ones needed to look into CORS and CSRF issues.
+func fetchMetadataLogField(header http.Header) []slog.Attr {
+	fields := []struct{ key, header string }{
+		{"origin", "Origin"},
+		{"referer", "Referer"},
+		{"secFetchSite", "Sec-Fetch-Site"},
+		{"secFetchMode", "Sec-Fetch-Mode"},
+		{"secFetchDest", "Sec-Fetch-Dest"},
+		{"secFetchUser", "Sec-Fetch-User"},
+	}
+
+	security := []slog.Attr{}
+	for _, f := range fields {
+		if v := header.Get(f.header); v != "" {
+			security = append(security, slog.Attr{Key: f.key, Value: slog.StringValue(v)})
+		}
+	}
+	return security
+}
+
+// requestCachingHeaders and responseCachingHeaders are the headers involved
+// in HTTP caching and conditional requests, by their logged name.
+var (
+	requestCachingHeaders = []struct{ key, header string }{
+		{"requestCacheControl", "Cache-Control"},
+		{"ifNoneMatch", "If-None-Match"},
+		{"ifModifiedSince", "If-Modified-Since"},
+	}
+	responseCachingHeaders = []struct{ key, header string }{
+		{"cacheControl", "Cache-Control"},
+		{"etag", "ETag"},
+	}
+)
+
+// cachingLogField returns the caching headers present in header, which are
+// the ones needed to debug 304 responses and CDN caching.
+func cachingLogField(header http.Header, fields []struct{ key, header string }) []slog.Attr {
+	caching := []slog.Attr{}
+	for _, f := range fields {
+		if v := header.Get(f.header); v != "" {
+			caching = append(caching, slog.Attr{Key: f.key, Value: slog.StringValue(v)})
+		}
+	}
+	return caching
+}
+
+// appendToGroup returns a copy of the group attribute with attrs appended.
+func appendToGroup(group slog.Attr, attrs ...slog.Attr) slog.Attr {
+	fields := append(slices.Clone(group.Value.Group()), attrs...)
+	return slog.Attr{Key: group.Key, Value: slog.GroupValue(fields...)}
+}
+
 func attrsToAnys(attr []slog.Attr) []any {
 	attrs := make([]any, len(attr))
 	for i, a := range attr {
@@ -335,6 +1229,14 @@ func statusLabel(status int) string {
 	}
 }
 
+// statusClass returns the low-cardinality class of the status code, ie. "2xx".
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
 func ErrAttr(err error) slog.Attr {
 	return slog.Any("err", err)
 }
@@ -364,7 +1266,10 @@ func LogEntry(ctx context.Context) *slog.Logger {
 
 func LogEntrySetField(ctx context.Context, key string, value slog.Value) {
 	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok {
-		entry.Logger = entry.Logger.With(slog.Attr{Key: key, Value: value})
+		entry.with(slog.Attr{Key: key, Value: value})
+		if entry.Options.DebugAttrs {
+			entry.debugFields(ctx, slog.Attr{Key: key, Value: value})
+		}
 	}
 }
 
@@ -376,6 +1281,149 @@ func LogEntrySetFields(ctx context.Context, fields map[string]interface{}) {
 			attrs[i] = slog.Attr{Key: k, Value: slog.AnyValue(v)}
 			i++
 		}
-		entry.Logger = entry.Logger.With(attrs...)
+		entry.with(attrs...)
+		if entry.Options.DebugAttrs {
+			entry.debugFields(ctx, attrs...)
+		}
+	}
+}
+
+// debugFields logs the fields set on the entry at debug level, along with the
+// location of the code which set them, which is two frames up.
+func (l *RequestLoggerEntry) debugFields(ctx context.Context, fields ...any) {
+	source := "unknown"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		source = fmt.Sprintf("%s:%d", file, line)
+	}
+	l.Logger.DebugContext(ctx, "httplog: fields set",
+		slog.Group("fields", fields...),
+		slog.Attr{Key: "setBy", Value: slog.StringValue(source)})
+}
+
+// MarkSensitive flags the request as handling sensitive data, which turns off
+// logging of the response body and headers regardless of the options. Useful
+// for handlers which only find out mid-flight that they're dealing with PII.
+//
+// NOTE: the request fields are attached to the logger as soon as the request
+// comes in, so these are not affected.
+func MarkSensitive(ctx context.Context) {
+	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok {
+		entry.sensitive = true
+	}
+}
+
+// SetUpstream records the address of the backend a reverse proxy forwarded
+// the request to, logged as "upstream.address" along with the response, to
+// debug load balancing across backends.
+func SetUpstream(ctx context.Context, addr string) {
+	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok {
+		entry.upstream = addr
+	}
+}
+
+// SetTag sets a tag on the request, ie. to flag requests routed to a canary,
+// logged in a "requestTags" group along with the response. Unlike the Tags
+// option, which are the same for all logs, these are specific to a request.
+// Setting a tag again replaces its value.
+func SetTag(ctx context.Context, key, value string) {
+	entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry)
+	if !ok {
+		return
+	}
+	for i := range entry.tags {
+		if entry.tags[i].Key == key {
+			entry.tags[i].Value = slog.StringValue(value)
+			return
+		}
+	}
+	entry.tags = append(entry.tags, slog.Attr{Key: key, Value: slog.StringValue(value)})
+}
+
+// SetOperation records the name of the logical operation served by the
+// request, logged as "operation" along with the response. This is meant for
+// RPC-style APIs, ie. JSON-RPC or GraphQL, whose single endpoint otherwise
+// makes all the logs look alike.
+func SetOperation(ctx context.Context, name string) {
+	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok {
+		entry.operation = name
+	}
+}
+
+// SetValidationErrors records the validation errors of the request, by field
+// name, logged as a "validationErrors" group along with the response, which
+// is then logged at least at warn level. RedactPatterns apply to the error
+// messages.
+func SetValidationErrors(ctx context.Context, errs map[string]string) {
+	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok {
+		entry.validationErrors = maps.Clone(errs)
+	}
+}
+
+// timing is the total duration of the sub-operations of a request with the
+// same name, see Timer.
+type timing struct {
+	name     string
+	duration time.Duration
+}
+
+// Timer times a sub-operation of the request, ie. a database query, until the
+// returned func is called. The durations are logged by name in milliseconds,
+// under a "timings" group along with the response, adding up the durations of
+// sub-operations with the same name. It's safe for concurrent use, ie.
+//
+//	defer httplog.Timer(ctx, "db")()
+func Timer(ctx context.Context, name string) func() {
+	entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry)
+	if !ok {
+		return func() {}
+	}
+
+	start := entry.Options.now()
+	return func() {
+		elapsed := entry.Options.now().Sub(start)
+
+		entry.timingsMu.Lock()
+		defer entry.timingsMu.Unlock()
+		for i := range entry.timings {
+			if entry.timings[i].name == name {
+				entry.timings[i].duration += elapsed
+				return
+			}
+		}
+		entry.timings = append(entry.timings, timing{name: name, duration: elapsed})
+	}
+}
+
+func (l *RequestLoggerEntry) timingsLogField() []any {
+	l.timingsMu.Lock()
+	defer l.timingsMu.Unlock()
+
+	timings := make([]any, len(l.timings))
+	for i, t := range l.timings {
+		timings[i] = slog.Attr{Key: t.name, Value: slog.Float64Value(float64(t.duration.Nanoseconds()) / 1000000.0)} // in milliseconds
+	}
+	return timings
+}
+
+// SetStatus overrides the status logged for the request, and from which the
+// log level is derived, ie. for a gateway which maps an internal 500 to a
+// 502 to log the status which makes sense to its clients. The overridden
+// status takes precedence over the status actually written, which is still
+// logged as "wireStatus" when they differ. The response is left as is.
+func SetStatus(ctx context.Context, status int) {
+	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok {
+		entry.status = status
+	}
+}
+
+// LogEntryClearFields removes all the fields set on the request-scoped logger
+// entry with LogEntrySetField and LogEntrySetFields, ie. when a request is
+// internally re-routed and the fields set along the way no longer apply. The
+// request fields set by the logger itself are kept.
+func LogEntryClearFields(ctx context.Context) {
+	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*RequestLoggerEntry); ok && entry.baseLogger != nil {
+		entry.Logger = entry.baseLogger
+		entry.accessLogger = entry.baseAccessLogger
+		entry.fieldsSize = entry.baseFieldsSize
 	}
 }
@@ -1,11 +1,24 @@
 package httplog
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
@@ -87,3 +100,689 @@ func (h *testHandler) WithAttrs(as []slog.Attr) slog.Handler {
 }
 
 func (h *testHandler) WithGroup(name string) slog.Handler { return h }
+
+func TestOmitMessage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{
+		JSON:        true,
+		Concise:     true,
+		OmitMessage: true,
+		Writer:      buf,
+	})
+
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record[slog.MessageKey]; ok {
+		t.Fatalf("expected no message field, got %v", record)
+	}
+	if _, ok := record["httpResponse"]; !ok {
+		t.Fatalf("expected httpResponse field, got %v", record)
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{
+		JSON:    true,
+		Concise: false,
+		Writer:  buf,
+	})
+
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/missing", nil))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var record struct {
+		HTTPRequest  map[string]any `json:"httpRequest"`
+		HTTPResponse map[string]any `json:"httpResponse"`
+	}
+	if err := json.Unmarshal(lines[len(lines)-1], &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.HTTPRequest["method"] != "HEAD" {
+		t.Fatalf("expected method HEAD, got %v", record.HTTPRequest["method"])
+	}
+	if _, ok := record.HTTPResponse["body"]; ok {
+		t.Fatalf("expected no response body for HEAD, got %v", record.HTTPResponse)
+	}
+}
+
+func TestHTTP3Request(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{JSON: true, Concise: true, Writer: buf})
+
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/3.0", 3, 0
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+
+	if rec.Body.String() != "ok" || !rec.Flushed {
+		t.Fatalf("expected the response to be written and flushed, got %q, flushed %v", rec.Body.String(), rec.Flushed)
+	}
+
+	var record struct {
+		HTTPRequest struct {
+			Proto string `json:"proto"`
+		} `json:"httpRequest"`
+		HTTPResponse struct {
+			Status int `json:"status"`
+			Bytes  int `json:"bytes"`
+		} `json:"httpResponse"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.HTTPRequest.Proto != "HTTP/3.0" {
+		t.Fatalf("expected proto HTTP/3.0, got %q", record.HTTPRequest.Proto)
+	}
+	if record.HTTPResponse.Status != 200 || record.HTTPResponse.Bytes != 2 {
+		t.Fatalf("expected status 200 and 2 bytes, got %+v", record.HTTPResponse)
+	}
+}
+
+func TestHandlerNilLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	h := Handler(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !bytes.Contains(buf.Bytes(), []byte(`"httpResponse"`)) {
+		t.Fatalf("expected request to be logged with slog.Default(), got %q", buf.String())
+	}
+}
+
+func TestLoggingTransportNilLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := &http.Client{Transport: NewLoggingTransport(nil, nil)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !strings.Contains(buf.String(), `"method":"GET"`) {
+		t.Fatalf("expected the request to be logged with slog.Default(), got %q", buf.String())
+	}
+}
+
+func TestNestedHandler(t *testing.T) {
+	outerBuf, innerBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	outer := NewLogger("test", Options{JSON: true, Concise: true, Writer: outerBuf})
+	inner := NewLogger("test", Options{JSON: true, Concise: true, Writer: innerBuf})
+
+	h := Handler(outer)(Handler(inner)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin", nil))
+
+	if outerBuf.Len() != 0 {
+		t.Fatalf("expected no logs from the outer logger, got %q", outerBuf.String())
+	}
+	if n := bytes.Count(innerBuf.Bytes(), []byte("\n")); n != 1 {
+		t.Fatalf("expected a single log from the inner logger, got %d: %q", n, innerBuf.String())
+	}
+}
+
+func TestNestedHandlerTrace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	outer := NewLogger("test", Options{JSON: true, Concise: true, Writer: io.Discard, Trace: &TraceOptions{}})
+	inner := NewLogger("test", Options{JSON: true, Concise: true, Writer: buf})
+
+	h := Handler(outer)(Handler(inner)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.Header.Set(_headerTraceID, "abc")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !strings.Contains(buf.String(), `"trace_id":"abc"`) {
+		t.Fatalf("expected the trace id of the outer logger, got %q", buf.String())
+	}
+}
+
+func TestPanicCount(t *testing.T) {
+	logger := NewLogger("test", Options{JSON: true, Writer: io.Discard})
+	h := RequestLogger(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oh no")
+	}))
+
+	before := PanicCount()
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}
+
+	if got := PanicCount() - before; got != 3 {
+		t.Fatalf("expected 3 panics, got %d", got)
+	}
+}
+
+func TestNow(t *testing.T) {
+	buf := &bytes.Buffer{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	logger := NewLogger("test", Options{
+		JSON:    true,
+		Concise: true,
+		Writer:  buf,
+		Now: func() time.Time {
+			now = now.Add(250 * time.Millisecond)
+			return now
+		},
+	})
+
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var record struct {
+		HTTPResponse struct {
+			Elapsed float64 `json:"elapsed"`
+		} `json:"httpResponse"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.HTTPResponse.Elapsed != 250 {
+		t.Fatalf("expected elapsed of 250ms, got %v", record.HTTPResponse.Elapsed)
+	}
+}
+
+func TestQuietDownPerHandler(t *testing.T) {
+	newHandler := func(buf *bytes.Buffer) http.Handler {
+		logger := NewLogger("test", Options{
+			JSON:            true,
+			Concise:         true,
+			QuietDownRoutes: []string{"/ping"},
+			QuietDownPeriod: time.Minute,
+			Writer:          buf,
+		})
+		return Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+
+	buf1, buf2 := &bytes.Buffer{}, &bytes.Buffer{}
+	h1, h2 := newHandler(buf1), newHandler(buf2)
+	for _, h := range []http.Handler{h1, h1, h2} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+	}
+
+	if n := bytes.Count(buf1.Bytes(), []byte("\n")); n != 1 {
+		t.Fatalf("expected the first handler to quiet down after 1 log, got %d logs", n)
+	}
+	if n := bytes.Count(buf2.Bytes(), []byte("\n")); n != 1 {
+		t.Fatalf("expected the second handler to log regardless of the first, got %d logs", n)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var sampled int
+	logger := NewLogger("test", Options{
+		JSON:   true,
+		Writer: buf,
+		Sampler: SamplerFunc(func(r *http.Request, status int, elapsed time.Duration) bool {
+			sampled = status
+			return true
+		}),
+	})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetStatus(r.Context(), http.StatusInternalServerError)
+		w.Write([]byte("upstream failed"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if sampled != http.StatusInternalServerError {
+		t.Fatalf("expected the sampler to get the overridden status, got %d", sampled)
+	}
+	for _, want := range []string{`"status":500`, `"wireStatus":200`, `"body":"upstream failed"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected %s in %q", want, buf.String())
+		}
+	}
+}
+
+func TestSummarizeInvalidJSONRedacted(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{
+		JSON:                 true,
+		Writer:               buf,
+		SummarizeInvalidJSON: true,
+		RedactPatterns:       []*regexp.Regexp{regexp.MustCompile(`secret-\w+`)},
+	})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"token":"secret-abcdef",}`))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !strings.Contains(buf.String(), `"bodyJSONError":{`) || !strings.Contains(buf.String(), `"offset":26`) || strings.Contains(buf.String(), "abcdef") {
+		t.Fatalf("expected a redacted summary of the JSON error, got %q", buf.String())
+	}
+}
+
+func TestBodyCutMidRune(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{JSON: true, Writer: buf, LogBodyBase64: true})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("x", 511) + "é"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if strings.Contains(buf.String(), "bodyBase64") || !strings.Contains(buf.String(), `"body":"`+strings.Repeat("x", 511)+`"`) {
+		t.Fatalf("expected the text body without the cut rune, got %q", buf.String())
+	}
+}
+
+func TestBodyLogSizeLimit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{JSON: true, Writer: buf, BodyLogSizeLimit: 100})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !strings.Contains(buf.String(), `"bodySize":1000,"bodyTooLarge":true`) || strings.Contains(buf.String(), "xxx") {
+		t.Fatalf("expected the body to be left out, got %q", buf.String())
+	}
+}
+
+func TestMaxLogSize(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{JSON: true, Writer: buf, MaxLogSize: 600})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LogEntrySetField(r.Context(), "note", slog.StringValue(strings.Repeat("n", 200)))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("b", 200)))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+strings.Repeat("p", 200), nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	response := lines[len(lines)-1]
+	if strings.Contains(response, `"body"`) || !strings.Contains(response, `"truncated":true`) {
+		t.Fatalf("expected the body to be dropped to fit the request fields, got %q", response)
+	}
+}
+
+func TestQuietDownBounded(t *testing.T) {
+	c := newCoolDownCache()
+	opts := Options{
+		QuietDownPeriod: time.Hour,
+		QuietDownKey:    func(r *http.Request) string { return r.RemoteAddr },
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < maxCoolDowns+100; i++ {
+		r.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		if c.inCooldown(r, &opts) {
+			t.Fatalf("expected %s not to be in cooldown", r.RemoteAddr)
+		}
+	}
+	if n := len(c.m); n > maxCoolDowns {
+		t.Fatalf("expected at most %d cooldown keys, got %d", maxCoolDowns, n)
+	}
+	if !c.inCooldown(r, &opts) {
+		t.Fatalf("expected the last key to be in cooldown")
+	}
+}
+
+func TestSanitizeAttrs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	opts := Options{Concise: true}.withDefaults()
+	logger := slog.New(SanitizeAttrs(NewPrettyHandler(buf, opts.handlerOptions())))
+	logger.Error("failed",
+		"err", errors.New("bad input\nINFO forged entry"),
+		"user", map[string]any{"name": "x\ny"})
+
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Fatalf("expected a single line, got %d: %q", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), `bad input\\nINFO forged entry`) {
+		t.Fatalf("expected the error to be escaped, got %q", buf.String())
+	}
+}
+
+func TestBodyResponseHeaderEmpty(t *testing.T) {
+	logger := NewLogger("test", Options{JSON: true, Writer: io.Discard, LogBodyResponseHeader: "X-Log-Body"})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Log-Body", "1")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Header().Get("X-Log-Body") != "" {
+		t.Fatalf("expected the marker header not to reach the client")
+	}
+}
+
+func TestSpanEventsRoute(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{JSON: true, Concise: true, Writer: buf, Trace: &TraceOptions{}, EmitSpanEvents: true})
+	r := chi.NewRouter()
+	r.Use(Handler(logger))
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+
+	if !strings.Contains(buf.String(), `"msg":"Span end: GET /users/{id}"`) {
+		t.Fatalf("expected the end event to be named after the route, got %q", buf.String())
+	}
+	if err := (Options{EmitSpanEvents: true}).Validate(); err == nil {
+		t.Fatalf("expected EmitSpanEvents without Trace to be reported")
+	}
+}
+
+func TestDualHandler(t *testing.T) {
+	jsonBuf, prettyBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	logger := slog.New(NewDualHandler(
+		jsonBuf, &Options{LogLevel: slog.LevelDebug},
+		prettyBuf, &Options{LogLevel: slog.LevelInfo, Concise: true}))
+	logger.Debug("debug", "key", "value")
+	logger.Info("info", "key", "value")
+
+	if n := strings.Count(jsonBuf.String(), "\n"); n != 2 {
+		t.Fatalf("expected both records as JSON, got %q", jsonBuf.String())
+	}
+	if strings.Contains(prettyBuf.String(), "debug") || strings.Contains(prettyBuf.String(), "key") {
+		t.Fatalf("expected a summary of the info record only, got %q", prettyBuf.String())
+	}
+}
+
+func TestPrettyHandlerWithGroup(t *testing.T) {
+	buf := &bytes.Buffer{}
+	opts := Options{Concise: true}.withDefaults()
+	logger := &Logger{
+		Logger:  slog.New(NewPrettyHandler(buf, opts.handlerOptions())).WithGroup("app").With("tenant", "acme"),
+		Options: opts,
+	}
+
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 logs, got %d: %q", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, `app: {tenant: "acme" httpRequest: {`) {
+			t.Fatalf("expected the request fields within the app group, got %q", line)
+		}
+		if strings.Count(line, "{") != strings.Count(line, "}") {
+			t.Fatalf("expected balanced groups, got %q", line)
+		}
+	}
+}
+
+// testHijack checks that handlers served by the logger with opts can still
+// hijack the connection, as for WebSocket upgrades.
+func testHijack(t *testing.T, opts Options) {
+	t.Helper()
+	opts.JSON = true
+	opts.Writer = io.Discard
+	srv := httptest.NewServer(Handler(NewLogger("test", opts))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "not a http.Hijacker", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hijacked" {
+		t.Fatalf("expected the handler to hijack the connection, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var mu sync.Mutex
+	logger := NewLogger("test", Options{
+		JSON:              true,
+		Concise:           true,
+		LogLevel:          slog.LevelDebug,
+		Writer:            writerFunc(func(p []byte) (int, error) { mu.Lock(); defer mu.Unlock(); return buf.Write(p) }),
+		HeartbeatInterval: 10 * time.Millisecond,
+	})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunk"))
+		time.Sleep(50 * time.Millisecond)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/download", nil))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(buf.String(), `"msg":"Request in progress","service":"test","httpRequest":{`) || !strings.Contains(buf.String(), `"bytes":5,"elapsed"`) {
+		t.Fatalf("expected progress logs of the request, got %q", buf.String())
+	}
+}
+
+func TestHeartbeatHijack(t *testing.T) {
+	testHijack(t, Options{HeartbeatInterval: time.Second})
+}
+
+func TestBodyResponseHeaderHijack(t *testing.T) {
+	testHijack(t, Options{LogBodyResponseHeader: "X-Log-Body"})
+}
+
+func TestStreamStatsHijack(t *testing.T) {
+	testHijack(t, Options{LogStreamStats: true})
+}
+
+func TestNewLoggerWithHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLoggerWithHandler("test", slog.NewJSONHandler(buf, nil), Options{Concise: true, Trace: &TraceOptions{}})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	traceID := rec.Header().Get(_headerTraceID)
+	if traceID == "" || !strings.Contains(buf.String(), `"service":"test","trace_id":"`+traceID+`"`) {
+		t.Fatalf("expected the defaults of the options to apply, got %q", buf.String())
+	}
+}
+
+func TestRenameAttrsEmptyGroup(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(RenameAttrs(slog.NewJSONHandler(buf, nil), map[string]string{"httpRequest": "request"}))
+	logger.WithGroup("").Info("Request: GET /", slog.Group("httpRequest", slog.String("method", "GET")))
+
+	if !strings.Contains(buf.String(), `"request":{"method":"GET"}`) {
+		t.Fatalf("expected top-level keys to be renamed, got %q", buf.String())
+	}
+}
+
+func handlerSourceTestHandler(w http.ResponseWriter, r *http.Request) {}
+
+func TestHandlerSource(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{JSON: true, Concise: true, Writer: buf, LogHandlerSource: true})
+	r := chi.NewRouter()
+	r.Use(Handler(logger))
+	r.With(middleware.NoCache).Get("/users/{id}", handlerSourceTestHandler)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+
+	want := `"handlerSource":{"function":"github.com/go-chi/httplog/v2.handlerSourceTestHandler"`
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("expected %s, got %q", want, buf.String())
+	}
+
+	// routes added after the first request are found as well
+	buf.Reset()
+	r.Get("/health", handlerSourceTestHandler)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("expected %s, got %q", want, buf.String())
+	}
+}
+
+func TestGraphQLOperation(t *testing.T) {
+	tests := []struct {
+		query  string
+		opType string
+		opName string
+	}{
+		{`{ user(id: 1) { name } }`, "query", ""},
+		{`query GetUser($id: ID!) { user(id: $id) { name } }`, "query", "GetUser"},
+		{`mutation { logout }`, "mutation", ""},
+		{"# comment { \nsubscription OnEvent { event }", "subscription", "OnEvent"},
+		{`fragment UserFields on User { name friends { name } } query GetUser { user { ...UserFields } }`, "query", "GetUser"},
+		{`fragment F on User { bio(format: "{") } fragment G on User { id } { user { ...F ...G } }`, "query", ""},
+	}
+	for _, tt := range tests {
+		opType, opName := graphQLOperation(tt.query)
+		if opType != tt.opType || opName != tt.opName {
+			t.Errorf("graphQLOperation(%q) = %q, %q, want %q, %q", tt.query, opType, opName, tt.opType, tt.opName)
+		}
+	}
+}
+
+func TestGraphQLAttrs(t *testing.T) {
+	attrs := GraphQLAttrs(`{"query": "mutation Login($password: String!) { login(password: $password) }", "variables": {"password": "hunter2"}}`)
+	got := slog.GroupValue(attrs...).String()
+	if want := "[operationType=mutation operationName=Login variables=[password=***]]"; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	if attrs := GraphQLAttrs(`[{"query": "{ a }"}, {"query": "{ b }"}]`); attrs != nil {
+		t.Fatalf("expected nil for a batched request, got %v", attrs)
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	t.Setenv("HTTPLOG_LEVEL", "warn")
+	t.Setenv("HTTPLOG_JSON", "true")
+	t.Setenv("HTTPLOG_QUIET_DOWN_ROUTES", "/ping, /health,")
+	t.Setenv("HTTPLOG_QUIET_DOWN_PERIOD", "5m")
+	t.Setenv("HTTPLOG_BODY_LOG_SIZE_LIMIT", "1024")
+	t.Setenv("HTTPLOG_TAGS", "env=prod, version=1.2")
+	t.Setenv("HTTPLOG_CONCISE", "maybe")
+	t.Setenv("APP_LEVEL", "verbose")
+
+	opts := OptionsFromEnv("")
+	if opts.LogLevel != slog.LevelWarn || !opts.JSON || opts.QuietDownPeriod != 5*time.Minute || opts.BodyLogSizeLimit != 1024 {
+		t.Fatalf("expected the options from the environment, got %+v", opts)
+	}
+	if !slices.Equal(opts.QuietDownRoutes, []string{"/ping", "/health"}) || opts.Tags["env"] != "prod" || opts.Tags["version"] != "1.2" {
+		t.Fatalf("expected the lists from the environment, got %v and %v", opts.QuietDownRoutes, opts.Tags)
+	}
+	if opts.Concise != defaultOptions.Concise || !strings.Contains(buf.String(), `"name":"HTTPLOG_CONCISE"`) {
+		t.Fatalf("expected the invalid value to be ignored with a warning, got %q", buf.String())
+	}
+
+	opts = OptionsFromEnv("APP")
+	if opts.LogLevel != defaultOptions.LogLevel || opts.JSON || !strings.Contains(buf.String(), `"name":"APP_LEVEL"`) {
+		t.Fatalf("expected only the variables of the prefix, got %+v", opts)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	levelVar := &slog.LevelVar{}
+	levelVar.Set(slog.LevelWarn)
+	h := LevelHandler(levelVar)
+
+	tests := []struct {
+		method string
+		body   string
+		status int
+		want   string
+		level  slog.Level
+	}{
+		{"GET", "", http.StatusOK, "WARN\n", slog.LevelWarn},
+		{"PUT", "debug\n", http.StatusOK, "DEBUG\n", slog.LevelDebug},
+		{"PUT", "INFO", http.StatusOK, "INFO\n", slog.LevelInfo},
+		{"PUT", "verbose", http.StatusBadRequest, "unknown log level \"verbose\"\n", slog.LevelInfo},
+		{"DELETE", "", http.StatusMethodNotAllowed, "Method Not Allowed\n", slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/admin/loglevel", strings.NewReader(tt.body)))
+		if rec.Code != tt.status || rec.Body.String() != tt.want || levelVar.Level() != tt.level {
+			t.Errorf("%s %q: expected %d %q at level %v, got %d %q at level %v",
+				tt.method, tt.body, tt.status, tt.want, tt.level, rec.Code, rec.Body.String(), levelVar.Level())
+		}
+		if tt.status == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "GET, HEAD, PUT" {
+			t.Errorf("expected the allowed methods, got %q", rec.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestTLSHandshakeListener(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger("test", Options{JSON: true, Concise: true, Writer: buf, LogTLSHandshake: true})
+	h := Handler(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// only for its certificate, config and client
+	ts := httptest.NewUnstartedServer(h)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	ts.Close()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := NewTLSHandshakeListener(inner, ts.TLS)
+	srv := &http.Server{Handler: h, ConnContext: ln.ConnContext}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	resp, err := ts.Client().Get("https://" + inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2 to be negotiated, got %s", resp.Proto)
+	}
+	if !strings.Contains(buf.String(), `"tls":{"handshake":`) {
+		t.Fatalf("expected the TLS handshake duration, got %q", buf.String())
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
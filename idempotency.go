@@ -0,0 +1,65 @@
+package httplog
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyKeysSize is the maximum number of idempotency keys tracked.
+	idempotencyKeysSize = 1024
+
+	// idempotencyKeysTTL is how long a request with the same idempotency key
+	// is considered a retry.
+	idempotencyKeysTTL = 10 * time.Minute
+)
+
+// keyCache is a small bounded set of recently seen keys, used to tell
+// whether a request is a retry of a previous one.
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+	size int
+	ttl  time.Duration
+}
+
+func newKeyCache(size int, ttl time.Duration) *keyCache {
+	return &keyCache{
+		keys: make(map[string]time.Time, size),
+		size: size,
+		ttl:  ttl,
+	}
+}
+
+// seen records key and reports whether it was already seen within the ttl.
+func (c *keyCache) seen(key string) bool {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last, ok := c.keys[key]
+	if !ok && len(c.keys) >= c.size {
+		c.evict(now)
+	}
+	c.keys[key] = now
+	return ok && now.Sub(last) < c.ttl
+}
+
+// evict drops the expired keys, or the oldest one if none have expired.
+func (c *keyCache) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for k, t := range c.keys {
+		if now.Sub(t) >= c.ttl {
+			delete(c.keys, k)
+			continue
+		}
+		if oldest.IsZero() || t.Before(oldest) {
+			oldestKey, oldest = k, t
+		}
+	}
+	if len(c.keys) >= c.size {
+		delete(c.keys, oldestKey)
+	}
+}
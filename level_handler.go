@@ -0,0 +1,48 @@
+package httplog
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// LevelHandler returns an http.Handler to read and change the log level at
+// runtime, ie. to turn on debug logs during an incident without a redeploy.
+// GET responds with the current level and PUT sets the level named in the
+// request body, ie.
+//
+//	curl -X PUT -d debug http://localhost:8080/admin/loglevel
+//
+// Set levelVar as Options.LevelVar of the logger for changes to take effect.
+//
+// NOTE: the handler doesn't do any authorization, it must be mounted behind
+// an authenticated route or on an internal port.
+func LevelHandler(levelVar *slog.LevelVar) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			name := strings.TrimSpace(string(body))
+			level := LevelByName(name)
+			if level == 0 && !strings.EqualFold(name, "INFO") {
+				http.Error(w, fmt.Sprintf("unknown log level %q", name), http.StatusBadRequest)
+				return
+			}
+			levelVar.Set(level)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, levelVar.Level())
+	})
+}
@@ -0,0 +1,128 @@
+// Package logrushandler provides a slog.Handler which writes through a logrus
+// logger, for the httplog request logger to be used by applications whose
+// logging is built on logrus. It's a module of its own, so that httplog
+// doesn't depend on logrus.
+package logrushandler
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+
+	"github.com/sirupsen/logrus"
+)
+
+// New returns a slog.Handler which writes through the logrus logger, so the
+// request logger can be used by applications whose logging is built on
+// logrus, ie.
+//
+//	logger := httplog.NewLoggerWithHandler("api", logrushandler.New(logrusLogger), opts)
+//
+// Levels are mapped to the closest logrus level, attributes to logrus fields
+// and groups to nested maps.
+func New(logger *logrus.Logger) slog.Handler {
+	return &logrusHandler{logger: logger, fields: logrus.Fields{}}
+}
+
+type logrusHandler struct {
+	logger *logrus.Logger
+	fields logrus.Fields
+	groups []string
+}
+
+var _ slog.Handler = &logrusHandler{}
+
+func (h *logrusHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.logger.IsLevelEnabled(logrusLevel(level))
+}
+
+func (h *logrusHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	fields := addAttrsToFields(h.fields, h.groups, attrs)
+
+	entry := logrus.NewEntry(h.logger).WithContext(ctx).WithFields(fields)
+	if !r.Time.IsZero() {
+		entry = entry.WithTime(r.Time)
+	}
+	entry.Log(logrusLevel(r.Level), r.Message)
+	return nil
+}
+
+func (h *logrusHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logrusHandler{
+		logger: h.logger,
+		fields: addAttrsToFields(h.fields, h.groups, attrs),
+		groups: h.groups,
+	}
+}
+
+func (h *logrusHandler) WithGroup(name string) slog.Handler {
+	return &logrusHandler{
+		logger: h.logger,
+		fields: h.fields,
+		groups: append(h.groups[:len(h.groups):len(h.groups)], name),
+	}
+}
+
+func logrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return logrus.DebugLevel
+	case level < slog.LevelWarn:
+		return logrus.InfoLevel
+	case level < slog.LevelError:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
+}
+
+// addAttrsToFields returns a copy of fields with the attributes added under
+// the nested maps of the groups, for loggers which take fields as a map.
+func addAttrsToFields(fields map[string]any, groups []string, attrs []slog.Attr) map[string]any {
+	fields = maps.Clone(fields)
+	if fields == nil {
+		fields = map[string]any{}
+	}
+
+	m := fields
+	for _, g := range groups {
+		group, _ := m[g].(map[string]any)
+		group = maps.Clone(group)
+		if group == nil {
+			group = map[string]any{}
+		}
+		m[g] = group
+		m = group
+	}
+
+	for _, a := range attrs {
+		addAttrToMap(m, a)
+	}
+	return fields
+}
+
+func addAttrToMap(m map[string]any, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() != slog.KindGroup {
+		m[a.Key] = a.Value.Any()
+		return
+	}
+
+	group := m
+	if a.Key != "" {
+		group = map[string]any{}
+		m[a.Key] = group
+	}
+	for _, ga := range a.Value.Group() {
+		addAttrToMap(group, ga)
+	}
+}
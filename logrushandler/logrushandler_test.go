@@ -0,0 +1,41 @@
+package logrushandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrusLogger := logrus.New()
+	logrusLogger.SetOutput(buf)
+	logrusLogger.SetFormatter(&logrus.JSONFormatter{})
+	logrusLogger.SetLevel(logrus.InfoLevel)
+
+	logger := slog.New(New(logrusLogger))
+	logger.Debug("dropped")
+	logger.With("service", "test").WithGroup("httpResponse").Warn("Response: 400 Client Error",
+		slog.Int("status", 400), slog.Group("header", slog.String("allow", "GET")))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single json log, got %q: %v", buf.String(), err)
+	}
+	if record["level"] != "warning" {
+		t.Fatalf("expected level warning, got %v", record["level"])
+	}
+	if record["service"] != "test" {
+		t.Fatalf("expected service field, got %v", record["service"])
+	}
+	response, _ := record["httpResponse"].(map[string]any)
+	if response["status"] != float64(400) {
+		t.Fatalf("expected nested status field, got %v", record["httpResponse"])
+	}
+	if header, _ := response["header"].(map[string]any); header["allow"] != "GET" {
+		t.Fatalf("expected nested header group, got %v", response["header"])
+	}
+}
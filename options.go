@@ -2,8 +2,14 @@ package httplog
 
 import (
 	"cmp"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
+	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -31,6 +37,16 @@ type Options struct {
 	// slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError
 	LogLevel slog.Level
 
+	// LevelVar, if set, is used as the minimum level in place of LogLevel, so
+	// the level can be changed at runtime, ie. with LevelHandler.
+	LevelVar *slog.LevelVar
+
+	// MinLevel, if set, is the minimum level of the request and response logs,
+	// whose level is raised to it when lower, ie. so they're still written
+	// when the level of the logger is set to warn to quiet down application
+	// logs. It takes precedence over the levels derived from the status code.
+	MinLevel slog.Leveler
+
 	// LevelFieldName sets the field name for the log level or severity.
 	// Some providers parse and search for different field names.
 	LevelFieldName string
@@ -39,6 +55,11 @@ type Options struct {
 	// Default is "msg".
 	MessageFieldName string
 
+	// OmitMessage drops the message from the request and response logs, leaving
+	// just the structured fields. Useful for log pipelines which never read the
+	// message and would rather save the bytes.
+	OmitMessage bool
+
 	// JSON enables structured logging output in json. Make sure to enable this
 	// in production mode so log aggregators can receive data in parsable format.
 	//
@@ -46,6 +67,17 @@ type Options struct {
 	// receive pretty output and stacktraces to stdout.
 	JSON bool
 
+	// EscapeControlChars escapes the control characters, such as CR and LF, of
+	// the logged messages and string values, to prevent forging log lines
+	// through crafted headers or bodies, see SanitizeAttrs.
+	EscapeControlChars bool
+
+	// WrapKey, if set, nests all the attributes of every log record under a
+	// group of this name, ie. {"time": ..., "msg": ..., "log": {...}}, for log
+	// collectors which expect the fields under a single key. Default is "",
+	// which keeps the attributes at the root level.
+	WrapKey string
+
 	// Concise mode includes fewer log details during the request flow. For example
 	// excluding details like request content length, user-agent and other details.
 	// This is useful if during development your console is too noisy.
@@ -56,6 +88,16 @@ type Options struct {
 	// name like prod/stg/dev
 	Tags map[string]string
 
+	// NormalizePath, if set, maps the logged request path to its template, ie.
+	// "/users/123" to "/users/{userId}" based on an OpenAPI spec, so requests
+	// can be grouped by endpoint. The url field keeps the raw path.
+	NormalizePath func(path string) string
+
+	// NormalizeMethod logs the request method in upper case, and non-standard
+	// methods, ie. WebDAV ones, as "OTHER", to keep aggregations by method
+	// clean. The message keeps the method as is.
+	NormalizeMethod bool
+
 	// RequestHeaders enables logging of all request headers, however sensitive
 	// headers like authorization, cookie and set-cookie are hidden.
 	RequestHeaders bool
@@ -63,9 +105,232 @@ type Options struct {
 	// HideRequestHeaders are additional requests headers which are redacted from the logs
 	HideRequestHeaders []string
 
+	// IdempotencyKeyHeader is the request header, typically "Idempotency-Key",
+	// holding the key clients send to safely retry requests. When set, the key
+	// is logged along with a "retry" flag telling whether the same key was seen
+	// in the last few minutes, which helps to debug duplicate processing.
+	IdempotencyKeyHeader string
+
+	// LogInFlight logs the number of requests being served when a request comes
+	// in, as "inFlight", see InFlight.
+	LogInFlight bool
+
+	// QueueDelayHeader is the request header, typically "X-Request-Start", in
+	// which a trusted load balancer sets the time it received the request. When
+	// set, the time spent between the load balancer and the handler is logged
+	// as "queueDelay" in milliseconds, which shows when requests queue up.
+	QueueDelayHeader string
+
+	// TimeoutHeader is the request header, ie. "grpc-timeout", in which clients
+	// declare how long they'll wait for a response. When set, the timeout is
+	// logged along with the response as "clientTimeout" in milliseconds, to
+	// compare with the time actually taken. Both the gRPC format ("100m") and
+	// Go durations ("5s") are understood.
+	TimeoutHeader string
+
+	// LogTLSInfo logs details of the TLS connection under a "tls" group, such
+	// as the server name requested by the client through SNI, which may differ
+	// from the Host header, and the protocol negotiated through ALPN, ie. "h2",
+	// as "alpn". Omitted for plain HTTP requests.
+	LogTLSInfo bool
+
+	// LogClientCert logs the subject of the client certificate, when one was
+	// presented, as "clientSubject" in the "tls" group. This tells which client
+	// identity made a request to mTLS services.
+	LogClientCert bool
+
+	// LogTLSHandshake logs the duration of the TLS handshake of the connection
+	// in milliseconds, as "handshake" in the "tls" group, for every request
+	// served over the connection. The handshake is only timed when the server
+	// serves a TLSHandshakeListener, see its docs for the wiring.
+	LogTLSHandshake bool
+
+	// LogBaggage logs the entries of the W3C baggage request header under a
+	// "baggage" group, which often carry business context such as the tenant.
+	// At most 16 entries are logged and RedactPatterns apply to the values.
+	LogBaggage bool
+
+	// VerboseOnError logs the request and response headers only for failed
+	// requests (status >= 400), as is already the case for the response body.
+	// The request headers are then logged along with the response, as
+	// "requestHeader", since the status isn't known when the request comes in.
+	VerboseOnError bool
+
+	// LogHeaderDigest logs a digest of all the request headers, their names and
+	// values, as "headerDigest", to group identical clients, ie. for abuse
+	// detection, without logging the headers. The digest is the first 16 hex
+	// digits of an HMAC-SHA256 keyed with HeaderDigestSalt.
+	LogHeaderDigest bool
+
+	// LogFingerprint logs a fingerprint of the request as "fingerprint", to
+	// group similar requests, ie. for rate limiting and abuse detection, without
+	// logging client IPs. The fingerprint is the first 16 hex digits of an
+	// HMAC-SHA256, keyed with HeaderDigestSalt, of the method, the path (as
+	// mapped by NormalizePath) and the client IP, from the remote address.
+	LogFingerprint bool
+
+	// HeaderDigestSalt is the key of the digests of LogHeaderDigest and
+	// LogFingerprint. Set it to get the same digests across instances and
+	// restarts, otherwise a random salt is used.
+	HeaderDigestSalt string
+
+	// LogFetchMetadata logs the Origin, Referer and Sec-Fetch-* request headers
+	// under a "security" group, which helps to diagnose CORS and CSRF issues
+	// without logging all request headers. Absent headers are omitted.
+	LogFetchMetadata bool
+
+	// LogContentNegotiation logs the Accept request header and the Content-Type
+	// of the response under a "negotiation" group along with the response, to
+	// debug why a client got an unexpected format or a 406 Not Acceptable.
+	LogContentNegotiation bool
+
+	// LogCachingHeaders logs the Cache-Control, If-None-Match and
+	// If-Modified-Since request headers and the Cache-Control and ETag response
+	// headers under a "caching" group along with the response, to debug 304 Not
+	// Modified responses and CDN caching. Absent headers are omitted.
+	LogCachingHeaders bool
+
+	// SkipDefaultHeaders leaves out the logged headers which have a common
+	// default value, ie. "Accept: */*", to keep the focus on the interesting
+	// ones. The default values are listed in DefaultHeaders.
+	SkipDefaultHeaders bool
+
 	// ResponseHeaders enables logging of all response headers.
 	ResponseHeaders bool
 
+	// LogBodyRoutes, if set, are the routes, as chi route patterns, for which
+	// the body of failed responses is logged, ie. "/api/orders/{id}". Routes
+	// ending with "*", ie. "/api/*", match all the routes below. Requests not
+	// routed with chi are matched by their path.
+	LogBodyRoutes []string
+
+	// LogBodyResponseHeader, if set, is a response header, ie. "X-Log-Body", by
+	// which handlers ask for the response body to be logged, whatever the
+	// status. The body is then only logged for responses with this header,
+	// which is removed before the response is sent. Note that when enabled,
+	// the response writer passed to handlers only implements http.Flusher
+	// directly, see LogStreamStats.
+	LogBodyResponseHeader string
+
+	// RedactPatterns are patterns, ie. of credit card numbers, whose matches in
+	// the logged response body and panic message are replaced with "***", as a
+	// safety net for personal data ending up in free text. Every pattern is run
+	// over the body, which adds up for long bodies and many patterns.
+	RedactPatterns []*regexp.Regexp
+
+	// BodyLogSizeLimit, if set, is the response size in bytes beyond which the
+	// body isn't buffered nor logged at all, not even truncated, and
+	// "bodyTooLarge" is logged instead, along with its "bodySize". This keeps
+	// large error pages and dumps out of the logs. Request bodies are never
+	// buffered nor logged, so it only applies to response bodies.
+	BodyLogSizeLimit int
+
+	// OnBodyTruncated, if set, is called when the logged body was cut short, ie.
+	// beyond the first 512 bytes or because of BodyLogSizeLimit, with the
+	// original and logged lengths in bytes, for metrics on how often bodies
+	// are clipped. Only response bodies are logged, so direction is always
+	// "response". It's called synchronously in the request path.
+	OnBodyTruncated func(r *http.Request, direction string, originalLen, loggedLen int)
+
+	// LogBodyAsJSON logs the response body of failed requests as a structured
+	// value when it is valid JSON, rather than as an escaped string. Bodies
+	// which aren't JSON, were truncated or nest too deep are still logged as
+	// a string.
+	LogBodyAsJSON bool
+
+	// SummarizeInvalidJSON logs a summary of the syntax error of malformed JSON
+	// response bodies as "bodyJSONError", with the error, its offset and a
+	// snippet of the body around it, in place of the whole body. Only applies
+	// to responses with a JSON content type which were logged in full.
+	SummarizeInvalidJSON bool
+
+	// LogRequestBytes logs the number of bytes the handler actually read from
+	// the request body as "requestBytes" along with the response, which unlike
+	// the Content-Length header is also known for streamed uploads. The body
+	// is only counted, not buffered.
+	LogRequestBytes bool
+
+	// LogSizeRatio logs the ratio of the response size to the request size as
+	// "sizeRatio", to spot amplification or compression anomalies. The request
+	// size is the Content-Length, or the bytes read with LogRequestBytes.
+	// Omitted for requests without a body.
+	LogSizeRatio bool
+
+	// LogBodyHash logs the first 16 hex digits of the SHA-256 hash of the
+	// request body as "requestBodyHash" along with the response, to spot
+	// duplicate submissions and correlate retries without logging payloads.
+	// Only the bytes the handler read, up to 1MB, are hashed.
+	LogBodyHash bool
+
+	// LogStreamStats logs the number of Write and Flush calls made on the
+	// response, which helps to diagnose buffering issues of streaming handlers
+	// such as server-sent events. Note that when enabled, the response writer
+	// passed to handlers only implements http.Flusher directly, other features
+	// like hijacking are reachable through http.ResponseController.
+	LogStreamStats bool
+
+	// HeartbeatInterval, if set, logs the progress of requests which are still
+	// being served after every interval, at debug level, with the bytes written
+	// so far and the time elapsed. This gives visibility into long downloads
+	// and streams, whose response log only comes once they're done. Note that
+	// when enabled, the response writer passed to handlers only implements
+	// http.Flusher directly, see LogStreamStats.
+	HeartbeatInterval time.Duration
+
+	// LogRuntimeStatsOnError attaches a "runtime" group with the goroutine count
+	// and memory usage to the logs of requests which panicked or failed with a
+	// 5xx status, to help diagnose resource exhaustion. It's only computed for
+	// such requests, as reading the memory stats briefly stops the world.
+	LogRuntimeStatsOnError bool
+
+	// CaptureSlowStack captures the stack trace of the handler when the request
+	// context hits its deadline while the handler is still running, and logs it
+	// as "deadlineStacktrace" to show where the handler got stuck. Capturing
+	// dumps the stacks of all goroutines, which is expensive on busy servers,
+	// and every request spawns a goroutine to watch for the deadline.
+	CaptureSlowStack bool
+
+	// StackTraceFormat is the representation of the logged stack traces of
+	// panics, in JSON mode, and of CaptureSlowStack: StackString (default),
+	// StackArray or StackFrames, whichever renders best in the log viewer.
+	StackTraceFormat StackTraceFormat
+
+	// MaxLogSize, if set, is the maximum estimated size in bytes of the response
+	// log, including the request fields, tags and fields set on the log entry.
+	// When exceeded, the largest optional response fields (body, JSON errors and
+	// headers) are dropped until it fits and a "truncated" field is added
+	// instead. This protects log pipelines with hard limits on the size of a
+	// line. Fields attached to the slog.Logger before NewLogger aren't counted.
+	MaxLogSize int
+
+	// LogBodyBase64 logs binary response bodies, such as protobuf errors, base64
+	// encoded as "bodyBase64" rather than as a mangled string. Bodies which are
+	// valid UTF-8 are logged as usual.
+	LogBodyBase64 bool
+
+	// BodyHexPrefixLen, if set, logs a hex dump of the first bytes of binary
+	// response bodies as "bodyHexPrefix", in place of the whole body. This is
+	// enough to diagnose malformed binary payloads. Takes precedence over
+	// LogBodyBase64.
+	BodyHexPrefixLen int
+
+	// GroupHTTPAttrs logs the request and response fields together under a
+	// single "http" group, ie. {"http": {"request": {...}, "response": {...}}},
+	// instead of the separate "httpRequest" and "httpResponse" groups. Some log
+	// UIs render a single nested object best.
+	GroupHTTPAttrs bool
+
+	// NestHTTPObjects logs the request and response fields under "request" and
+	// "response" groups, instead of "httpRequest" and "httpResponse", for log
+	// pipelines which expect plain nested objects. GroupHTTPAttrs takes
+	// precedence.
+	NestHTTPObjects bool
+
+	// SkipUserAgents are substrings of the User-Agent of clients whose requests
+	// aren't logged, ie. "kube-probe" or "ELB-HealthChecker" to drop the noise
+	// of orchestrator health checks.
+	SkipUserAgents []string
+
 	// QuietDownRoutes are routes which are temporarily excluded from logging for a QuietDownPeriod after it occurs
 	// for the first time
 	// to cancel noise from logging for routes that are known to be noisy.
@@ -75,6 +340,23 @@ type Options struct {
 	// if the route is in QuietDownRoutes
 	QuietDownPeriod time.Duration
 
+	// QuietDownKey, if set, returns the key under which a request is quieted
+	// down instead of its route, ie. the client IP to quiet down a noisy client
+	// across all routes. Requests for which it returns "" are always logged.
+	// When set, QuietDownRoutes is not used.
+	QuietDownKey func(r *http.Request) string
+
+	// LogRequestOnly logs requests as they come in, even in Concise mode, and
+	// no response log, except for requests which panicked. This suits high
+	// volume fire-and-forget endpoints, such as webhook receivers, whose
+	// responses are of no interest.
+	LogRequestOnly bool
+
+	// Sampler, if set, is asked once the request has completed whether its
+	// response should be logged. Note that in non-concise mode the request
+	// itself has already been logged by then.
+	Sampler Sampler
+
 	// TimeFieldFormat defines the time format of the Time field, defaulting to "time.RFC3339Nano" see options at:
 	// https://pkg.go.dev/time#pkg-constants
 	TimeFieldFormat string
@@ -83,25 +365,96 @@ type Options struct {
 	// Some providers parse and search for different field names.
 	TimeFieldName string
 
+	// LogID attaches a unique "logID" to every request and response log, unlike
+	// the trace id which is shared by all the logs of a request. This lets log
+	// systems reference a specific log unambiguously.
+	LogID bool
+
+	// StatusLabelFunc, if set, returns the label of the status code used in the
+	// response log message in place of the coarse default ("OK", "Client Error",
+	// etc.). Set it to http.StatusText to use the canonical reason phrases.
+	StatusLabelFunc func(status int) string
+
+	// StatusClassFieldName sets the field name for the response status class,
+	// ie. "2xx" or "5xx", which is handy for grouping in dashboards.
+	// If set to "" then it'll be disabled.
+	StatusClassFieldName string
+
+	// ReceivedTimeFieldName sets the field name for the time the request was
+	// received, logged along with the response, whose time field is when the
+	// response completed. Formatted with TimeFieldFormat.
+	// If set to "" then it'll be disabled.
+	ReceivedTimeFieldName string
+
 	// SourceFieldName sets the field name for the source field which logs
 	// the location in the program source code where the logger was called.
 	// If set to "" then it'll be disabled.
 	SourceFieldName string
 
+	// LogHandlerSource logs the source location of the handler which served
+	// the request as "handlerSource" along with the response, unlike the
+	// source of SourceFieldName which is the location of the logger call.
+	// The handler is looked up from the chi route matched for the request, so
+	// this requires routing with chi. The routes are walked once, on the
+	// first request, so routes added later aren't found.
+	LogHandlerSource bool
+
 	// Writer is the log writer, default is os.Stdout
 	Writer io.Writer
 
+	// AccessLogger, if set, writes the request and response logs, while the
+	// logger returned by LogEntry, which handlers use for their own logs,
+	// still writes through the Logger. This separates access logs from
+	// application logs, ie. into different files. The request fields and the
+	// fields set with LogEntrySetField are attached to both, but not the
+	// service and tags, which are only attached to the Logger.
+	AccessLogger *slog.Logger
+
 	// ReplaceAttrsOverride allows to add custom logic to replace attributes
 	// in addition to the default logic set in this package.
 	ReplaceAttrsOverride func(groups []string, a slog.Attr) slog.Attr
 
+	// GCPErrorReporting formats the logs of requests which panicked the way
+	// Google Cloud Error Reporting expects, so panics show up in its console
+	// and not just in Cloud Logging. Set MessageFieldName to "message" and
+	// LevelFieldName to "severity" along with it.
+	GCPErrorReporting bool
+
+	// DebugAttrs logs, at debug level, every field set with LogEntrySetField or
+	// LogEntrySetFields along with the location of the code which set it. This
+	// helps to track down which middleware set a surprising field.
+	DebugAttrs bool
+
+	// OnLog, if set, is called with the level and attributes of every response
+	// log just before it is written, giving tests and metrics exporters a
+	// structured view of it. Attributes attached to the request logger, such
+	// as the request fields, are not included. OnLog is called synchronously
+	// in the request path, so it should return quickly.
+	OnLog func(ctx context.Context, level slog.Level, attrs []slog.Attr)
+
+	// Now, if set, is the clock used to time requests, in place of time.Now.
+	// It's mainly meant for tests, to get reproducible durations in the logs.
+	// The time of the log records themselves is still set by slog.
+	Now func() time.Time
+
 	// Trace is the configuration for distributed tracing.
 	Trace *TraceOptions
+
+	// EmitSpanEvents logs the start and the end of the span of every request,
+	// with its trace and span ids and, at the end, its duration, under a
+	// "span" group. This gives a minimal trace purely from logs for systems
+	// without a tracer. The span is named after the method and the chi route
+	// pattern of the request, which is only known at its end, so the start
+	// event is named after the path instead. Requires Trace to be set.
+	EmitSpanEvents bool
 }
 
 // TraceOptions are the configuration options for distributed tracing.
 type TraceOptions struct {
 	// HeaderTrace is the header key used to read the trace id from the incoming request.
+	// The trace id is also set on the response under this header, before the
+	// handler runs so it's there even if the handler panics, for clients to
+	// report it along with bugs.
 	// Default is "X-Trace-ID".
 	HeaderTrace string
 	// LogFieldTrace is the field name used to log the trace id.
@@ -110,11 +463,95 @@ type TraceOptions struct {
 	// LogFieldSpan is the field name used to log the span id.
 	// Default is "span_id".
 	LogFieldSpan string
+	// LogFieldParentSpan is the field name used to log the id of the parent
+	// span, read from the W3C traceparent header of the incoming request when
+	// present, whose trace id is also used if there's no HeaderTrace header.
+	// Default is "parent_span_id".
+	LogFieldParentSpan string
 }
 
 // Configure will set new options for the httplog instance and behaviour
 // of underlying slog pkg and its global logger.
 func (l *Logger) Configure(opts Options) {
+	l.configure(opts, nil)
+}
+
+// configure sets the options, writing through handler, or else through the
+// pretty or JSON handler set up by the options.
+func (l *Logger) configure(opts Options, handler slog.Handler) {
+	opts = opts.withDefaults()
+
+	l.Options = opts
+
+	if handler == nil {
+		handlerOpts := opts.handlerOptions()
+
+		writer := opts.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+
+		if !opts.JSON {
+			handler = NewPrettyHandler(writer, handlerOpts)
+		} else {
+			handler = slog.NewJSONHandler(writer, handlerOpts)
+		}
+	}
+	if opts.EscapeControlChars {
+		handler = SanitizeAttrs(handler)
+	}
+	l.Logger = slog.New(handler)
+
+	if err := opts.Validate(); err != nil {
+		l.Logger.Warn("httplog: options have no effect", ErrAttr(err))
+	}
+
+	if opts.WrapKey != "" {
+		l.Logger = l.Logger.WithGroup(opts.WrapKey)
+	}
+
+	l.Options.Trace = opts.Trace
+	if l.Options.Trace != nil {
+		l.Options.Trace.HeaderTrace = cmp.Or(l.Options.Trace.HeaderTrace, _headerTraceID)
+		l.Options.Trace.LogFieldTrace = cmp.Or(l.Options.Trace.LogFieldTrace, _logFieldTrace)
+		l.Options.Trace.LogFieldSpan = cmp.Or(l.Options.Trace.LogFieldSpan, _logFieldSpan)
+		if l.Options.Trace.LogFieldParentSpan == "" {
+			l.Options.Trace.LogFieldParentSpan = _logFieldParentSpan
+		}
+	}
+}
+
+// Validate reports options which contradict each other, leaving some of them
+// without effect, ie. ResponseHeaders along with Concise, which never logs
+// response headers. Configure logs a warning with the error, if any.
+func (opts Options) Validate() error {
+	var errs []error
+	if opts.Concise {
+		ignored := []struct {
+			name string
+			set  bool
+		}{
+			{"ResponseHeaders", opts.ResponseHeaders},
+			{"Tags", len(opts.Tags) > 0},
+			{"LogBodyAsJSON", opts.LogBodyAsJSON},
+			{"LogBodyBase64", opts.LogBodyBase64},
+			{"BodyHexPrefixLen", opts.BodyHexPrefixLen > 0},
+			{"BodyLogSizeLimit", opts.BodyLogSizeLimit > 0},
+		}
+		for _, o := range ignored {
+			if o.set {
+				errs = append(errs, fmt.Errorf("httplog: %s is ignored in Concise mode", o.name))
+			}
+		}
+	}
+	if opts.EmitSpanEvents && opts.Trace == nil {
+		errs = append(errs, errors.New("httplog: EmitSpanEvents is ignored without Trace"))
+	}
+	return errors.Join(errs...)
+}
+
+// withDefaults returns a copy of opts with unset fields filled in.
+func (opts Options) withDefaults() Options {
 	// if opts.LogLevel is not set
 	// it would be 0 which is LevelInfo
 
@@ -130,7 +567,7 @@ func (l *Logger) Configure(opts Options) {
 		opts.TimeFieldName = "timestamp"
 	}
 
-	if len(opts.QuietDownRoutes) > 0 {
+	if len(opts.QuietDownRoutes) > 0 || opts.QuietDownKey != nil {
 		if opts.QuietDownPeriod == 0 {
 			opts.QuietDownPeriod = 5 * time.Minute
 		}
@@ -141,8 +578,28 @@ func (l *Logger) Configure(opts Options) {
 		opts.HideRequestHeaders[i] = strings.ToLower(header)
 	}
 
-	l.Options = opts
+	return opts
+}
+
+// now returns the current time according to opts.Now.
+func (opts Options) now() time.Time {
+	if opts.Now != nil {
+		return opts.Now()
+	}
+	return time.Now()
+}
 
+// atLeastMinLevel returns level raised to opts.MinLevel, if set.
+func (opts Options) atLeastMinLevel(level slog.Level) slog.Level {
+	if opts.MinLevel != nil {
+		return max(level, opts.MinLevel.Level())
+	}
+	return level
+}
+
+// handlerOptions builds the slog.HandlerOptions, including the attribute
+// renaming logic, for the given options.
+func (opts Options) handlerOptions() *slog.HandlerOptions {
 	var addSource bool
 	if opts.SourceFieldName != "" {
 		addSource = true
@@ -156,6 +613,9 @@ func (l *Logger) Configure(opts Options) {
 			a.Key = opts.TimeFieldName
 			a.Value = slog.StringValue(a.Value.Time().Format(opts.TimeFieldFormat))
 		case slog.MessageKey:
+			if opts.OmitMessage && len(groups) == 0 && a.Value.String() == "" {
+				return slog.Attr{}
+			}
 			if opts.MessageFieldName != "" {
 				a.Key = opts.MessageFieldName
 			}
@@ -171,28 +631,15 @@ func (l *Logger) Configure(opts Options) {
 		return a
 	}
 
-	handlerOpts := &slog.HandlerOptions{
-		Level:       opts.LogLevel,
-		ReplaceAttr: replaceAttrs,
-		AddSource:   addSource,
-	}
-
-	writer := opts.Writer
-	if writer == nil {
-		writer = os.Stdout
-	}
-
-	if !opts.JSON {
-		l.Logger = slog.New(NewPrettyHandler(writer, handlerOpts))
-	} else {
-		l.Logger = slog.New(slog.NewJSONHandler(writer, handlerOpts))
+	var level slog.Leveler = opts.LogLevel
+	if opts.LevelVar != nil {
+		level = opts.LevelVar
 	}
 
-	l.Options.Trace = opts.Trace
-	if l.Options.Trace != nil {
-		l.Options.Trace.HeaderTrace = cmp.Or(l.Options.Trace.HeaderTrace, _headerTraceID)
-		l.Options.Trace.LogFieldTrace = cmp.Or(l.Options.Trace.LogFieldTrace, _logFieldTrace)
-		l.Options.Trace.LogFieldSpan = cmp.Or(l.Options.Trace.LogFieldSpan, _logFieldSpan)
+	return &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: replaceAttrs,
+		AddSource:   addSource,
 	}
 }
 
@@ -210,3 +657,107 @@ func LevelByName(name string) slog.Level {
 		return 0
 	}
 }
+
+// Option configures Options, see NewOptions.
+type Option func(*Options)
+
+// NewOptions returns the default options with the given opts applied, as an
+// alternative to an Options struct literal, ie.
+//
+//	httplog.NewLogger("app", httplog.NewOptions(
+//		httplog.WithLevel(slog.LevelDebug),
+//		httplog.WithJSON(true),
+//	))
+func NewOptions(opts ...Option) Options {
+	o := defaultOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// DevOptions returns options suited to local development, as a starting point
+// to tweak: pretty output at debug level, with the request and response
+// headers, the body of failed responses and the source of the logs.
+func DevOptions() Options {
+	opts := defaultOptions
+	opts.LogLevel = slog.LevelDebug
+	opts.JSON = false
+	opts.Concise = false
+	opts.RequestHeaders = true
+	opts.ResponseHeaders = true
+	opts.SourceFieldName = "source"
+	return opts
+}
+
+// ProdOptions returns options suited to production, as a starting point to
+// tweak: concise JSON output at info level, with the request headers only
+// logged for failed requests, with more headers hidden, and a tenth of the
+// successful requests sampled. Failed requests are always logged.
+func ProdOptions() Options {
+	opts := defaultOptions
+	opts.LogLevel = slog.LevelInfo
+	opts.JSON = true
+	opts.Concise = true
+	opts.RequestHeaders = true
+	opts.VerboseOnError = true
+	opts.SkipDefaultHeaders = true
+	opts.HideRequestHeaders = []string{"proxy-authorization", "x-api-key", "x-auth-token", "x-csrf-token"}
+	opts.Sampler = SamplerFunc(func(r *http.Request, status int, elapsed time.Duration) bool {
+		return status >= 400 || rand.Float64() < 0.1
+	})
+	return opts
+}
+
+// WithLevel sets Options.LogLevel.
+func WithLevel(level slog.Level) Option {
+	return func(o *Options) { o.LogLevel = level }
+}
+
+// WithJSON sets Options.JSON.
+func WithJSON(json bool) Option {
+	return func(o *Options) { o.JSON = json }
+}
+
+// WithConcise sets Options.Concise.
+func WithConcise(concise bool) Option {
+	return func(o *Options) { o.Concise = concise }
+}
+
+// WithTags sets Options.Tags.
+func WithTags(tags map[string]string) Option {
+	return func(o *Options) { o.Tags = tags }
+}
+
+// WithRequestHeaders sets Options.RequestHeaders.
+func WithRequestHeaders(requestHeaders bool) Option {
+	return func(o *Options) { o.RequestHeaders = requestHeaders }
+}
+
+// WithResponseHeaders sets Options.ResponseHeaders.
+func WithResponseHeaders(responseHeaders bool) Option {
+	return func(o *Options) { o.ResponseHeaders = responseHeaders }
+}
+
+// WithHideRequestHeaders sets Options.HideRequestHeaders.
+func WithHideRequestHeaders(headers ...string) Option {
+	return func(o *Options) { o.HideRequestHeaders = headers }
+}
+
+// WithQuietDownRoutes sets Options.QuietDownRoutes and Options.QuietDownPeriod.
+func WithQuietDownRoutes(period time.Duration, routes ...string) Option {
+	return func(o *Options) {
+		o.QuietDownRoutes = routes
+		o.QuietDownPeriod = period
+	}
+}
+
+// WithWriter sets Options.Writer.
+func WithWriter(w io.Writer) Option {
+	return func(o *Options) { o.Writer = w }
+}
+
+// WithTrace sets Options.Trace.
+func WithTrace(trace *TraceOptions) Option {
+	return func(o *Options) { o.Trace = trace }
+}
@@ -0,0 +1,69 @@
+package httplog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// RenameAttrs returns a slog.Handler which renames the top-level attribute
+// keys found in mapping before passing records on to base. Attributes inside
+// groups are left as is. This is handy to stay compatible with the field
+// names expected by existing dashboards or log pipelines.
+func RenameAttrs(base slog.Handler, mapping map[string]string) slog.Handler {
+	return &renameHandler{base: base, mapping: mapping}
+}
+
+type renameHandler struct {
+	base    slog.Handler
+	mapping map[string]string
+	inGroup bool
+}
+
+var _ slog.Handler = &renameHandler{}
+
+func (h *renameHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.base.Enabled(ctx, level)
+}
+
+func (h *renameHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.inGroup {
+		return h.base.Handle(ctx, r)
+	}
+
+	r2 := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		r2.AddAttrs(h.rename(a))
+		return true
+	})
+	return h.base.Handle(ctx, r2)
+}
+
+func (h *renameHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if !h.inGroup {
+		renamed := make([]slog.Attr, len(attrs))
+		for i, a := range attrs {
+			renamed[i] = h.rename(a)
+		}
+		attrs = renamed
+	}
+	return &renameHandler{base: h.base.WithAttrs(attrs), mapping: h.mapping, inGroup: h.inGroup}
+}
+
+func (h *renameHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	if !h.inGroup {
+		if key, ok := h.mapping[name]; ok {
+			name = key
+		}
+	}
+	return &renameHandler{base: h.base.WithGroup(name), mapping: h.mapping, inGroup: true}
+}
+
+func (h *renameHandler) rename(a slog.Attr) slog.Attr {
+	if key, ok := h.mapping[a.Key]; ok {
+		a.Key = key
+	}
+	return a
+}
@@ -0,0 +1,22 @@
+package httplog
+
+import (
+	"net/http"
+	"time"
+)
+
+// Sampler decides whether the response log of a request should be written,
+// allowing for custom sampling strategies, for example keeping all errors
+// while only logging a fraction of the successful requests.
+type Sampler interface {
+	Sample(r *http.Request, status int, elapsed time.Duration) bool
+}
+
+// SamplerFunc is an adapter to allow the use of ordinary functions as a
+// Sampler.
+type SamplerFunc func(r *http.Request, status int, elapsed time.Duration) bool
+
+// Sample calls f(r, status, elapsed).
+func (f SamplerFunc) Sample(r *http.Request, status int, elapsed time.Duration) bool {
+	return f(r, status, elapsed)
+}
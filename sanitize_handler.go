@@ -0,0 +1,128 @@
+package httplog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+// SanitizeAttrs returns a slog.Handler which escapes the control characters,
+// such as CR and LF, of the message and string attribute values of records,
+// ie. "\n" is written as `\n`, before passing them on to base. Errors,
+// fmt.Stringers and other values written as text are escaped as well, and
+// maps and slices are sanitized element-wise. This prevents
+// log forging through crafted headers or bodies with output formats which
+// don't escape them, like the pretty output. Note that multi-line values,
+// such as stack traces, end up on a single line.
+func SanitizeAttrs(base slog.Handler) slog.Handler {
+	return &sanitizeHandler{base: base}
+}
+
+type sanitizeHandler struct {
+	base slog.Handler
+}
+
+var _ slog.Handler = &sanitizeHandler{}
+
+func (h *sanitizeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.base.Enabled(ctx, level)
+}
+
+func (h *sanitizeHandler) Handle(ctx context.Context, r slog.Record) error {
+	r2 := slog.NewRecord(r.Time, r.Level, escapeControlChars(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		r2.AddAttrs(sanitizeAttr(a))
+		return true
+	})
+	return h.base.Handle(ctx, r2)
+}
+
+func (h *sanitizeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sanitized := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		sanitized[i] = sanitizeAttr(a)
+	}
+	return &sanitizeHandler{base: h.base.WithAttrs(sanitized)}
+}
+
+func (h *sanitizeHandler) WithGroup(name string) slog.Handler {
+	return &sanitizeHandler{base: h.base.WithGroup(escapeControlChars(name))}
+}
+
+func sanitizeAttr(a slog.Attr) slog.Attr {
+	a.Key = escapeControlChars(a.Key)
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(escapeControlChars(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		sanitized := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			sanitized[i] = sanitizeAttr(ga)
+		}
+		a.Value = slog.GroupValue(sanitized...)
+	case slog.KindAny:
+		a.Value = slog.AnyValue(sanitizeAny(a.Value.Any()))
+	}
+	return a
+}
+
+// sanitizeAny returns v with the control characters of its text escaped.
+// Values are left as is when there's nothing to escape, so that handlers
+// keep formatting them their own way, ie. as JSON objects.
+func sanitizeAny(v any) any {
+	switch v := v.(type) {
+	case nil, bool, int, int64, uint64, float64:
+		return v
+	case string:
+		return escapeControlChars(v)
+	case map[string]any:
+		sanitized := make(map[string]any, len(v))
+		for k, mv := range v {
+			sanitized[escapeControlChars(k)] = sanitizeAny(mv)
+		}
+		return sanitized
+	case []any:
+		sanitized := make([]any, len(v))
+		for i, sv := range v {
+			sanitized[i] = sanitizeAny(sv)
+		}
+		return sanitized
+	}
+
+	// errors, fmt.Stringers and anything else end up written as text by
+	// some handlers, which is what's checked
+	s := fmt.Sprint(v)
+	if escaped := escapeControlChars(s); escaped != s {
+		return escaped
+	}
+	return v
+}
+
+// escapeControlChars returns s with its control characters escaped.
+func escapeControlChars(s string) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s) + 8)
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
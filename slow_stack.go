@@ -0,0 +1,85 @@
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+)
+
+// stackWatcher captures the stack of a handler's goroutine if the request
+// context hits its deadline while the handler is still running, showing
+// where the handler got stuck.
+type stackWatcher struct {
+	done    chan struct{}
+	stopped chan struct{}
+	stack   []byte
+}
+
+// watchStack starts watching ctx on behalf of the calling goroutine.
+func watchStack(ctx context.Context) *stackWatcher {
+	sw := &stackWatcher{
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	id := goroutineID()
+
+	go func() {
+		defer close(sw.stopped)
+		select {
+		case <-sw.done:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				sw.stack = goroutineStack(id)
+			}
+		}
+	}()
+	return sw
+}
+
+// Stop stops watching and returns the captured stack, if any.
+func (sw *stackWatcher) Stop() []byte {
+	close(sw.done)
+	<-sw.stopped
+	return sw.stack
+}
+
+// goroutineID returns the id of the calling goroutine, as found in the
+// header of its stack trace, ie. "goroutine 42 [running]:".
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		if _, err := strconv.ParseUint(string(buf[:i]), 10, 64); err == nil {
+			return string(buf[:i])
+		}
+	}
+	return ""
+}
+
+// goroutineStack returns the stack trace of the goroutine with the given id.
+// This dumps the stacks of all goroutines, which is expensive.
+func goroutineStack(id string) []byte {
+	if id == "" {
+		return nil
+	}
+
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, []byte("goroutine "+id+" ")) {
+			return stack
+		}
+	}
+	return nil
+}
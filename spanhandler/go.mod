@@ -0,0 +1,8 @@
+module github.com/go-chi/httplog/spanhandler
+
+go 1.21
+
+require (
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
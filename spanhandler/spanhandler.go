@@ -0,0 +1,129 @@
+// Package spanhandler provides a slog.Handler which records logs as events on
+// OpenTelemetry spans, ie. for the httplog request logs to show up on the
+// traces of the requests. It's a module of its own, so that httplog doesn't
+// depend on OpenTelemetry.
+package spanhandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New returns a slog.Handler which records every log as an event
+// on the active OpenTelemetry span of the context, when there is one, with the
+// log attributes as event attributes. Records are also passed on to base,
+// unless it's nil, in which case logs only end up on spans. The records passed
+// on carry the ids of the span, as "trace_id" and "span_id", to correlate logs
+// with traces; leave httplog's TraceOptions unset to avoid logging them twice.
+//
+// The span is looked up with the OpenTelemetry trace API, so the context
+// given to the logger must carry it, ie. by logging with InfoContext, which
+// is what the request logger does with the request context:
+//
+//	logger := httplog.NewLoggerWithHandler("api", spanhandler.New(base), opts)
+func New(base slog.Handler) slog.Handler {
+	return &spanEventHandler{base: base}
+}
+
+type spanEventHandler struct {
+	base   slog.Handler
+	attrs  []attribute.KeyValue
+	prefix string
+}
+
+var _ slog.Handler = &spanEventHandler{}
+
+func (h *spanEventHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.base == nil {
+		return true
+	}
+	return h.base.Enabled(ctx, level) || trace.SpanFromContext(ctx).IsRecording()
+}
+
+func (h *spanEventHandler) Handle(ctx context.Context, r slog.Record) error {
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		attrs := make([]attribute.KeyValue, 0, len(h.attrs)+r.NumAttrs()+1)
+		attrs = append(attrs, h.attrs...)
+		attrs = append(attrs, attribute.String("level", r.Level.String()))
+		r.Attrs(func(a slog.Attr) bool {
+			attrs = appendSpanAttrs(attrs, h.prefix, a)
+			return true
+		})
+		span.AddEvent(r.Message, trace.WithTimestamp(r.Time), trace.WithAttributes(attrs...))
+	}
+
+	if h.base == nil || !h.base.Enabled(ctx, r.Level) {
+		return nil
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.base.Handle(ctx, r)
+}
+
+func (h *spanEventHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := h.clone()
+	for _, a := range attrs {
+		h2.attrs = appendSpanAttrs(h2.attrs, h2.prefix, a)
+	}
+	if h.base != nil {
+		h2.base = h.base.WithAttrs(attrs)
+	}
+	return h2
+}
+
+func (h *spanEventHandler) WithGroup(name string) slog.Handler {
+	h2 := h.clone()
+	h2.prefix = h.prefix + name + "."
+	if h.base != nil {
+		h2.base = h.base.WithGroup(name)
+	}
+	return h2
+}
+
+func (h *spanEventHandler) clone() *spanEventHandler {
+	return &spanEventHandler{
+		base:   h.base,
+		attrs:  append([]attribute.KeyValue{}, h.attrs...),
+		prefix: h.prefix,
+	}
+}
+
+// appendSpanAttrs flattens the slog attribute into span attributes, using
+// dotted keys for the attributes of groups.
+func appendSpanAttrs(attrs []attribute.KeyValue, prefix string, a slog.Attr) []attribute.KeyValue {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+
+	key := prefix + a.Key
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		groupPrefix := prefix
+		if a.Key != "" {
+			groupPrefix = key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			attrs = appendSpanAttrs(attrs, groupPrefix, ga)
+		}
+		return attrs
+	case slog.KindString:
+		return append(attrs, attribute.String(key, a.Value.String()))
+	case slog.KindBool:
+		return append(attrs, attribute.Bool(key, a.Value.Bool()))
+	case slog.KindInt64:
+		return append(attrs, attribute.Int64(key, a.Value.Int64()))
+	case slog.KindFloat64:
+		return append(attrs, attribute.Float64(key, a.Value.Float64()))
+	default:
+		return append(attrs, attribute.String(key, fmt.Sprint(a.Value.Any())))
+	}
+}
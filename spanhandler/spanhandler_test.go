@@ -0,0 +1,69 @@
+package spanhandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordingSpan is a recording span which keeps the events added to it.
+type recordingSpan struct {
+	trace.Span
+	sc     trace.SpanContext
+	events []string
+	attrs  []attribute.KeyValue
+}
+
+func (s *recordingSpan) IsRecording() bool { return true }
+
+func (s *recordingSpan) SpanContext() trace.SpanContext { return s.sc }
+
+func (s *recordingSpan) AddEvent(name string, options ...trace.EventOption) {
+	s.events = append(s.events, name)
+	cfg := trace.NewEventConfig(options...)
+	s.attrs = append(s.attrs, cfg.Attributes()...)
+}
+
+func TestHandler(t *testing.T) {
+	span := &recordingSpan{
+		Span: trace.SpanFromContext(context.Background()),
+		sc: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{0x01, 0x02},
+			SpanID:     trace.SpanID{0x03, 0x04},
+			TraceFlags: trace.FlagsSampled,
+		}),
+	}
+	ctx := trace.ContextWithSpan(context.Background(), span)
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(New(slog.NewJSONHandler(buf, nil)))
+	logger.With("service", "test").InfoContext(ctx, "Response: 200 OK", slog.Group("httpResponse", slog.Int("status", 200)))
+
+	if len(span.events) != 1 || span.events[0] != "Response: 200 OK" {
+		t.Fatalf("expected the log as a span event, got %v", span.events)
+	}
+	want := map[attribute.Key]bool{"service": true, "level": true, "httpResponse.status": true}
+	for _, a := range span.attrs {
+		delete(want, a.Key)
+	}
+	if len(want) > 0 {
+		t.Fatalf("expected the event attributes to include %v, got %v", want, span.attrs)
+	}
+
+	var record struct {
+		Service string `json:"service"`
+		TraceID string `json:"trace_id"`
+		SpanID  string `json:"span_id"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single json log, got %q: %v", buf.String(), err)
+	}
+	if record.TraceID != span.sc.TraceID().String() || record.SpanID != span.sc.SpanID().String() {
+		t.Fatalf("expected the span ids on the record, got %q", buf.String())
+	}
+}
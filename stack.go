@@ -0,0 +1,86 @@
+package httplog
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// StackTraceFormat is the representation of the stack traces logged, see
+// Options.StackTraceFormat.
+type StackTraceFormat int
+
+const (
+	// StackString logs the stack trace as is, in a single string.
+	StackString StackTraceFormat = iota
+
+	// StackArray logs the stack trace as an array of "file:line" strings.
+	StackArray
+
+	// StackFrames logs the stack trace as an array of objects with the file,
+	// line and func of every frame.
+	StackFrames
+)
+
+// stackFrame is a frame of a stack trace.
+type stackFrame struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Func string `json:"func"`
+}
+
+// parseStack parses the frames of a stack trace formatted by the runtime, as
+// returned by debug.Stack, ie.
+//
+//	goroutine 1 [running]:
+//	main.main()
+//		/app/main.go:10 +0x1d
+func parseStack(stack []byte) []stackFrame {
+	frames := []stackFrame{}
+	lines := strings.Split(string(stack), "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		if !strings.HasPrefix(lines[i+1], "\t") || strings.HasPrefix(lines[i], "\t") {
+			continue
+		}
+		fn := lines[i]
+		if strings.HasPrefix(fn, "created by ") {
+			fn, _, _ = strings.Cut(strings.TrimPrefix(fn, "created by "), " in goroutine ")
+		} else if j := strings.LastIndexByte(fn, '('); j > 0 && strings.HasSuffix(fn, ")") {
+			fn = fn[:j]
+		}
+
+		location := strings.TrimPrefix(lines[i+1], "\t")
+		if j := strings.LastIndex(location, " +0x"); j > 0 {
+			location = location[:j]
+		}
+		file, line := location, 0
+		if j := strings.LastIndexByte(location, ':'); j > 0 {
+			if n, err := strconv.Atoi(location[j+1:]); err == nil {
+				file, line = location[:j], n
+			}
+		}
+
+		frames = append(frames, stackFrame{File: file, Line: line, Func: fn})
+		i++
+	}
+	return frames
+}
+
+// stackValue returns the stack trace in the given format.
+func stackValue(stack []byte, format StackTraceFormat) slog.Value {
+	switch format {
+	case StackArray:
+		frames := parseStack(stack)
+		locations := make([]string, len(frames))
+		for i, f := range frames {
+			locations[i] = fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+		return slog.AnyValue(locations)
+	case StackFrames:
+		return slog.AnyValue(parseStack(stack))
+	default:
+		return slog.StringValue(string(bytes.TrimSpace(stack)))
+	}
+}
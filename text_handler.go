@@ -14,8 +14,7 @@ type PrettyHandler struct {
 	opts              *slog.HandlerOptions
 	w                 io.Writer
 	preformattedAttrs *bytes.Buffer
-	groupPrefix       *string
-	groupOpen         bool
+	openGroups        int
 	mu                sync.Mutex
 }
 
@@ -92,12 +91,8 @@ func (h *PrettyHandler) Handle(ctx context.Context, r slog.Record) error {
 	buf.WriteString(" ")
 	// write preformatted attrs to buf
 	buf.Write(h.preformattedAttrs.Bytes())
-	// close group in preformatted attrs if open\
-	if h.groupOpen {
-		cW(h.preformattedAttrs, true, nWhite, "%s", "}")
-	}
 
-	// write record level attrs to buf
+	// write record level attrs to buf, within the open groups
 	attrs := []slog.Attr{}
 	r.Attrs(func(attr slog.Attr) bool {
 		attrs = append(attrs, attr)
@@ -105,6 +100,11 @@ func (h *PrettyHandler) Handle(ctx context.Context, r slog.Record) error {
 	})
 	writeAttrs(buf, attrs, false)
 
+	// close the groups opened with WithGroup
+	for i := 0; i < h.openGroups; i++ {
+		cW(buf, true, nWhite, "%s", "} ")
+	}
+
 	buf.WriteString("\n")
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -182,16 +182,16 @@ func levelColor(l slog.Level) []byte {
 	}
 }
 
+// WithGroup nests the attributes added afterwards, including those of the
+// records, under a group, which is kept open until the record is written.
 func (h *PrettyHandler) WithGroup(name string) slog.Handler {
-	h2 := h.clone()
-	if h2.groupPrefix != nil {
-		// end old group
-		cW(h2.preformattedAttrs, true, nWhite, "}")
+	if name == "" {
+		return h
 	}
-	h2.groupOpen = true
-	h2.groupPrefix = &name
+	h2 := h.clone()
+	h2.openGroups++
 	cW(h2.preformattedAttrs, true, bMagenta, "%s: {", name)
-	return h
+	return h2
 }
 
 func (h *PrettyHandler) clone() *PrettyHandler {
@@ -201,7 +201,7 @@ func (h *PrettyHandler) clone() *PrettyHandler {
 	return &PrettyHandler{
 		opts:              h.opts,
 		w:                 h.w,
-		groupPrefix:       h.groupPrefix,
+		openGroups:        h.openGroups,
 		preformattedAttrs: newBuffer,
 	}
 }
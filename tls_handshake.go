@@ -0,0 +1,111 @@
+package httplog
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// TLSHandshakeListener is a TLS listener which times the TLS handshakes of the
+// connections it accepts, for Options.LogTLSHandshake. The server must serve
+// the listener and use its ConnContext, ie.
+//
+//	ln := httplog.NewTLSHandshakeListener(tcpListener, tlsConfig)
+//	srv := &http.Server{Handler: r, ConnContext: ln.ConnContext}
+//	srv.Serve(ln)
+//
+// As with tls.NewListener, the config must have certificates and list "h2"
+// in NextProtos for HTTP/2 to be negotiated. The config is cloned for every
+// connection, to hook the handshake.
+type TLSHandshakeListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+// tlsHandshakeTiming is the duration of the TLS handshake of a connection,
+// from the ClientHello until the handshake is verified.
+type tlsHandshakeTiming struct {
+	start    time.Time
+	duration time.Duration
+}
+
+// timedConn is the connection under a *tls.Conn accepted by the listener,
+// carrying the handshake timing. The *tls.Conn itself can't be wrapped, as
+// http.Server checks for it to serve TLS.
+type timedConn struct {
+	net.Conn
+	timing *tlsHandshakeTiming
+}
+
+var _contextKeyTLSHandshake = &contextKey{"tls_handshake"}
+
+// NewTLSHandshakeListener returns a listener which accepts connections from
+// inner and serves TLS over them with config, timing the handshakes.
+func NewTLSHandshakeListener(inner net.Listener, config *tls.Config) *TLSHandshakeListener {
+	return &TLSHandshakeListener{Listener: inner, config: config}
+}
+
+func (l *TLSHandshakeListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	timing := &tlsHandshakeTiming{}
+	config := timingTLSConfig(l.config, timing)
+	getConfigForClient := config.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		timing.start = time.Now()
+		if getConfigForClient == nil {
+			return nil, nil
+		}
+		cfg, err := getConfigForClient(hello)
+		if cfg == nil || err != nil {
+			return cfg, err
+		}
+		return timingTLSConfig(cfg, timing), nil
+	}
+
+	return tls.Server(&timedConn{Conn: c, timing: timing}, config), nil
+}
+
+// ConnContext passes the handshake timing of the connection to the requests
+// served over it, to be set as the ConnContext of the http.Server.
+func (l *TLSHandshakeListener) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		if tc, ok := tc.NetConn().(*timedConn); ok {
+			ctx = context.WithValue(ctx, _contextKeyTLSHandshake, tc.timing)
+		}
+	}
+	return ctx
+}
+
+// timingTLSConfig returns a clone of config which records the end of the
+// handshake in timing.
+func timingTLSConfig(config *tls.Config, timing *tlsHandshakeTiming) *tls.Config {
+	config = config.Clone()
+	verifyConnection := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verifyConnection != nil {
+			if err := verifyConnection(state); err != nil {
+				return err
+			}
+		}
+		if !timing.start.IsZero() {
+			timing.duration = time.Since(timing.start)
+		}
+		return nil
+	}
+	return config
+}
+
+// tlsHandshakeDuration returns the duration of the TLS handshake of the
+// connection of the request, if it was timed by a TLSHandshakeListener.
+func tlsHandshakeDuration(ctx context.Context) (time.Duration, bool) {
+	timing, ok := ctx.Value(_contextKeyTLSHandshake).(*tlsHandshakeTiming)
+	if !ok || timing.duration == 0 {
+		return 0, false
+	}
+	return timing.duration, true
+}
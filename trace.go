@@ -2,15 +2,24 @@ package httplog
 
 import (
 	"cmp"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 const (
 	_headerTraceID = "X-Trace-ID"
 	_logFieldTrace = "trace_id"
 	_logFieldSpan  = "span_id"
+
+	_logFieldParentSpan = "parent_span_id"
 )
 
 type contextKey struct {
@@ -24,6 +33,8 @@ func (k *contextKey) String() string {
 var (
 	_contextKeyTrace = &contextKey{"trace_id"}
 	_contextKeySpan  = &contextKey{"span_id"}
+
+	_contextKeyParentSpan = &contextKey{"parent_span_id"}
 )
 
 // NeTransport returns a new http.RoundTripper that propagates the TraceID.
@@ -49,6 +60,103 @@ func (t traceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 	return t.Base.RoundTrip(r)
 }
 
+// NewLoggingTransport returns a new http.RoundTripper that logs outgoing
+// requests, with their method, url, status and duration, using the logger.
+// Requests made with the context of an incoming request carry its trace id,
+// which correlates the calls to downstream services with it.
+func NewLoggingTransport(logger *Logger, base http.RoundTripper) http.RoundTripper {
+	if logger == nil {
+		slog.Default().Warn("httplog: nil logger, falling back to slog.Default()")
+		logger = &Logger{Logger: slog.Default(), Options: defaultOptions.withDefaults()}
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return loggingTransport{
+		Logger: logger,
+		Base:   base,
+	}
+}
+
+type loggingTransport struct {
+	Logger *Logger
+	Base   http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t1 := time.Now()
+	resp, err := t.Base.RoundTrip(r)
+	elapsed := time.Since(t1)
+
+	fields := []any{
+		slog.Attr{Key: "url", Value: slog.StringValue(redactLocation(r.URL.String()))},
+		slog.Attr{Key: "method", Value: slog.StringValue(r.Method)},
+		slog.Attr{Key: "elapsed", Value: slog.Float64Value(float64(elapsed.Nanoseconds()) / 1000000.0)}, // in milliseconds
+	}
+	attrs := []slog.Attr{}
+	if t.Logger.Options.Trace != nil {
+		if traceID, ok := r.Context().Value(_contextKeyTrace).(string); ok {
+			attrs = append(attrs, slog.Attr{Key: t.Logger.Options.Trace.LogFieldTrace, Value: slog.StringValue(traceID)})
+		}
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.Group("httpClientRequest", fields...), ErrAttr(err))
+		t.Logger.LogAttrs(r.Context(), slog.LevelError, fmt.Sprintf("Outgoing: %s %s failed", r.Method, r.URL.Host), attrs...)
+		return resp, err
+	}
+
+	fields = append(fields, slog.Attr{Key: "status", Value: slog.IntValue(resp.StatusCode)})
+	attrs = append(attrs, slog.Group("httpClientRequest", fields...))
+	msg := fmt.Sprintf("Outgoing: %s %s => %d %s", r.Method, r.URL.Host, resp.StatusCode, statusLabel(resp.StatusCode))
+	t.Logger.LogAttrs(r.Context(), statusLevel(resp.StatusCode), msg, attrs...)
+	return resp, nil
+}
+
+// parseTraceparent returns the trace id and the parent span id of a W3C
+// traceparent header, ie. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceparent(traceparent string) (traceID, parentSpanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	for _, id := range parts[:3] {
+		if _, err := hex.DecodeString(id); err != nil {
+			return "", "", false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		// all zero ids are invalid
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// logSpanEvent logs the start or end of the span of a request, along with
+// the duration of the span when it ends, see Options.EmitSpanEvents.
+func logSpanEvent(ctx context.Context, logger *slog.Logger, event, name string, duration time.Duration) {
+	fields := []any{
+		slog.Attr{Key: "event", Value: slog.StringValue(event)},
+		slog.Attr{Key: "name", Value: slog.StringValue(name)},
+	}
+	if event == "end" {
+		fields = append(fields, slog.Attr{Key: "duration", Value: slog.Float64Value(float64(duration.Nanoseconds()) / 1000000.0)}) // in milliseconds
+	}
+	logger.LogAttrs(ctx, slog.LevelInfo, fmt.Sprintf("Span %s: %s", event, name), slog.Group("span", fields...))
+}
+
+// spanName names the span of a request after its chi route pattern, once it
+// was routed, or else after its path, as mapped by NormalizePath if set.
+func spanName(r *http.Request, options *Options) string {
+	path := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		path = rctx.RoutePattern()
+	} else if options.NormalizePath != nil {
+		path = options.NormalizePath(path)
+	}
+	return r.Method + " " + path
+}
+
 func newID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
@@ -1,8 +1,24 @@
 package httplog
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
 	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 // limitBuffer is used to pipe response body information from the
@@ -35,3 +51,338 @@ func (b limitBuffer) Write(p []byte) (n int, err error) {
 func (b limitBuffer) Read(p []byte) (n int, err error) {
 	return b.Buffer.Read(p)
 }
+
+// maxJSONBodyDepth is the deepest nesting of a JSON body which is still
+// logged as a structured value.
+const maxJSONBodyDepth = 10
+
+// jsonBodyValue returns the parsed body as a structured value, if the body
+// is valid JSON which doesn't nest deeper than maxJSONBodyDepth.
+func jsonBodyValue(body []byte) (slog.Value, bool) {
+	if !json.Valid(body) {
+		return slog.Value{}, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return slog.Value{}, false
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxJSONBodyDepth {
+				return slog.Value{}, false
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return slog.Value{}, false
+	}
+	return slog.AnyValue(v), true
+}
+
+// jsonErrorSnippetLen is the number of bytes of a malformed JSON body logged
+// on each side of the syntax error.
+const jsonErrorSnippetLen = 32
+
+// jsonErrorValue returns a summary of the syntax error of a malformed JSON
+// body, with the offset of the error and a snippet of the body around it. The
+// body is parsed as is, for the offset to be right, and the snippet redacted.
+func jsonErrorValue(body []byte, patterns []*regexp.Regexp) (slog.Value, bool) {
+	var v any
+	var syntaxErr *json.SyntaxError
+	if err := json.Unmarshal(body, &v); !errors.As(err, &syntaxErr) {
+		return slog.Value{}, false
+	}
+
+	offset := int(min(syntaxErr.Offset, int64(len(body))))
+	snippet := body[max(offset-jsonErrorSnippetLen, 0):min(offset+jsonErrorSnippetLen, len(body))]
+	return slog.GroupValue(
+		slog.Attr{Key: "error", Value: slog.StringValue(syntaxErr.Error())},
+		slog.Attr{Key: "offset", Value: slog.Int64Value(syntaxErr.Offset)},
+		slog.Attr{Key: "snippet", Value: slog.StringValue(string(redactPatterns(snippet, patterns)))},
+	), true
+}
+
+// streamStatsWriter counts the Write and Flush calls made by the handler,
+// which tells how a streamed response was chunked.
+type streamStatsWriter struct {
+	middleware.WrapResponseWriter
+	writes  int
+	flushes int
+}
+
+func (w *streamStatsWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.WrapResponseWriter.Write(p)
+}
+
+func (w *streamStatsWriter) Flush() {
+	w.flushes++
+	if f, ok := w.WrapResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, ie. for WebSocket upgrades,
+// which middleware.WrapResponseWriter doesn't expose by itself.
+func (w *streamStatsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.WrapResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the features, such as
+// hijacking, of the wrapped response writer.
+func (w *streamStatsWriter) Unwrap() http.ResponseWriter {
+	return w.WrapResponseWriter
+}
+
+// bodyMarkerWriter removes the marker header, by which the handler asks for
+// the response body to be logged, before the headers are written.
+type bodyMarkerWriter struct {
+	http.ResponseWriter
+	header  string
+	checked bool
+	marked  bool
+}
+
+func (w *bodyMarkerWriter) check() {
+	if w.checked {
+		return
+	}
+	w.checked = true
+	if w.Header().Get(w.header) != "" {
+		w.marked = true
+		w.Header().Del(w.header)
+	}
+}
+
+func (w *bodyMarkerWriter) WriteHeader(status int) {
+	w.check()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyMarkerWriter) Write(p []byte) (int, error) {
+	w.check()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *bodyMarkerWriter) Flush() {
+	w.check()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, ie. for WebSocket upgrades,
+// when the wrapped response writer supports it.
+func (w *bodyMarkerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the features, such as
+// hijacking, of the wrapped response writer.
+func (w *bodyMarkerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// droppableLogFields are the optional response fields which fitLogSize may
+// drop: the body, in any of its forms, and headers.
+var droppableLogFields = map[string]bool{
+	"body":          true,
+	"bodyJSONError": true,
+	"bodyBase64":    true,
+	"bodyHexPrefix": true,
+	"header":        true,
+	"requestHeader": true,
+}
+
+// fitLogSize drops the largest of the droppableLogFields, until the estimated
+// size of the fields is within maxSize. When any field is dropped, a truncated
+// marker is added instead.
+func fitLogSize(fields []any, maxSize int) []any {
+	size := 0
+	for _, f := range fields {
+		if a, ok := f.(slog.Attr); ok {
+			size += attrSize(a)
+		}
+	}
+
+	truncated := false
+	for size > maxSize {
+		largest := -1
+		for i, f := range fields {
+			a, ok := f.(slog.Attr)
+			if !ok || !droppableLogFields[a.Key] {
+				continue
+			}
+			if largest < 0 || attrSize(a) > attrSize(fields[largest].(slog.Attr)) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			break
+		}
+		size -= attrSize(fields[largest].(slog.Attr))
+		fields = append(fields[:largest], fields[largest+1:]...)
+		truncated = true
+	}
+
+	if truncated {
+		fields = append(fields, slog.Attr{Key: "truncated", Value: slog.BoolValue(true)})
+	}
+	return fields
+}
+
+// trimPartialRune returns b without its last rune if it's incomplete, as when
+// b was cut at a size limit, so that valid UTF-8 text isn't taken for binary.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// fieldsSize estimates the serialized size of fields, given as slog.Attrs or
+// key-value pairs as for slog.Logger.With.
+func fieldsSize(fields []any) int {
+	var r slog.Record
+	r.Add(fields...)
+	size := 0
+	r.Attrs(func(a slog.Attr) bool {
+		size += attrSize(a)
+		return true
+	})
+	return size
+}
+
+// attrSize estimates the serialized size of the attribute.
+func attrSize(a slog.Attr) int {
+	size := len(a.Key) + 4 // quotes, colon and separator
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			size += attrSize(ga)
+		}
+		return size + 2
+	}
+	return size + len(a.Value.String())
+}
+
+// parseRequestStart parses the time a load balancer received the request
+// from a header such as X-Request-Start. Both the bare and the "t=" prefixed
+// forms are accepted, with the unit (seconds, milliseconds or microseconds
+// since the epoch) guessed from the magnitude of the value.
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	if v == "" {
+		return time.Time{}, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return time.Time{}, false
+	}
+
+	switch {
+	case f > 1e15: // microseconds
+		return time.UnixMicro(int64(f)), true
+	case f > 1e12: // milliseconds
+		return time.UnixMicro(int64(f * 1e3)), true
+	default: // seconds
+		return time.UnixMicro(int64(f * 1e6)), true
+	}
+}
+
+// countingReader counts the bytes read from the request body, which is the
+// only way to know the size of requests without a Content-Length.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// maxBodyHashSize is the number of bytes of the request body hashed at most.
+const maxBodyHashSize = 1 << 20
+
+// hashingReader hashes the first maxBodyHashSize bytes read from the request
+// body, to tell identical payloads apart without logging them.
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	n    int
+}
+
+func newHashingReader(body io.ReadCloser) *hashingReader {
+	return &hashingReader{ReadCloser: body, hash: sha256.New()}
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if m := min(n, maxBodyHashSize-r.n); m > 0 {
+		r.hash.Write(p[:m])
+		r.n += m
+	}
+	return n, err
+}
+
+// Sum returns the first 16 hex digits of the hash, or "" if nothing was read.
+func (r *hashingReader) Sum() string {
+	if r.n == 0 {
+		return ""
+	}
+	return hex.EncodeToString(r.hash.Sum(nil))[:16]
+}
+
+// grpcTimeoutUnits are the units of the grpc-timeout header.
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseTimeout parses the timeout declared by a client, either in the gRPC
+// format, ie. "100m" for 100 milliseconds, or as a Go duration like "5s".
+func parseTimeout(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if len(v) < 2 {
+		return 0, false
+	}
+	if unit, ok := grpcTimeoutUnits[v[len(v)-1]]; ok {
+		if n, err := strconv.ParseInt(v[:len(v)-1], 10, 64); err == nil && n >= 0 {
+			return time.Duration(n) * unit, true
+		}
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return d, true
+	}
+	return 0, false
+}
+
+// redactPatterns replaces the matches of the patterns in b with "***".
+func redactPatterns(b []byte, patterns []*regexp.Regexp) []byte {
+	for _, re := range patterns {
+		b = re.ReplaceAllLiteral(b, []byte("***"))
+	}
+	return b
+}
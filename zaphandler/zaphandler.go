@@ -0,0 +1,129 @@
+// Package zaphandler provides a slog.Handler which writes through a zap
+// logger, for the httplog request logger to be used by applications whose
+// logging is built on zap. It's a module of its own, so that httplog doesn't
+// depend on zap.
+package zaphandler
+
+import (
+	"context"
+	"log/slog"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a slog.Handler which writes through the zap logger, so the
+// request logger can be used by applications whose logging is built on zap,
+// ie.
+//
+//	logger := httplog.NewLoggerWithHandler("api", zaphandler.New(zapLogger), opts)
+//
+// Levels are mapped to the closest zap level, attributes to zap fields and
+// groups to nested objects. Since records pass through slog, the caller
+// reported by zap is not meaningful and AddSource should be used instead.
+func New(logger *zap.Logger) slog.Handler {
+	return &zapHandler{logger: logger}
+}
+
+type zapHandler struct {
+	logger *zap.Logger
+}
+
+var _ slog.Handler = &zapHandler{}
+
+func (h *zapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.logger.Core().Enabled(zapLevel(level))
+}
+
+func (h *zapHandler) Handle(ctx context.Context, r slog.Record) error {
+	ce := h.logger.Check(zapLevel(r.Level), r.Message)
+	if ce == nil {
+		return nil
+	}
+	if !r.Time.IsZero() {
+		ce.Time = r.Time
+	}
+
+	fields := make([]zap.Field, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendZapFields(fields, a)
+		return true
+	})
+	ce.Write(fields...)
+	return nil
+}
+
+func (h *zapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]zap.Field, 0, len(attrs))
+	for _, a := range attrs {
+		fields = appendZapFields(fields, a)
+	}
+	return &zapHandler{logger: h.logger.With(fields...)}
+}
+
+func (h *zapHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &zapHandler{logger: h.logger.With(zap.Namespace(name))}
+}
+
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return zapcore.DebugLevel
+	case level < slog.LevelWarn:
+		return zapcore.InfoLevel
+	case level < slog.LevelError:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+func appendZapFields(fields []zap.Field, a slog.Attr) []zap.Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		if a.Key == "" {
+			// inline the attributes of groups without a key, as slog does
+			for _, ga := range a.Value.Group() {
+				fields = appendZapFields(fields, ga)
+			}
+			return fields
+		}
+		return append(fields, zap.Object(a.Key, zapGroup(a.Value.Group())))
+	case slog.KindString:
+		return append(fields, zap.String(a.Key, a.Value.String()))
+	case slog.KindBool:
+		return append(fields, zap.Bool(a.Key, a.Value.Bool()))
+	case slog.KindInt64:
+		return append(fields, zap.Int64(a.Key, a.Value.Int64()))
+	case slog.KindUint64:
+		return append(fields, zap.Uint64(a.Key, a.Value.Uint64()))
+	case slog.KindFloat64:
+		return append(fields, zap.Float64(a.Key, a.Value.Float64()))
+	case slog.KindDuration:
+		return append(fields, zap.Duration(a.Key, a.Value.Duration()))
+	case slog.KindTime:
+		return append(fields, zap.Time(a.Key, a.Value.Time()))
+	default:
+		return append(fields, zap.Any(a.Key, a.Value.Any()))
+	}
+}
+
+// zapGroup marshals the attributes of a slog group as a zap object.
+type zapGroup []slog.Attr
+
+func (g zapGroup) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, a := range g {
+		for _, f := range appendZapFields(nil, a) {
+			f.AddTo(enc)
+		}
+	}
+	return nil
+}
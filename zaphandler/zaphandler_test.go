@@ -0,0 +1,61 @@
+package zaphandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  string
+	}{
+		{slog.LevelDebug, "debug"},
+		{slog.LevelInfo, "info"},
+		{slog.LevelWarn, "warn"},
+		{slog.LevelError, "error"},
+		{slog.LevelError + 4, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(buf), zapcore.DebugLevel)
+			logger := slog.New(New(zap.New(core)))
+			logger.With("service", "test").WithGroup("").WithGroup("httpRequest").Log(context.Background(), tt.level, "Request: GET /",
+				slog.String("method", "GET"), slog.Group("header", slog.String("accept", "*/*")))
+
+			var record struct {
+				Level       string `json:"level"`
+				Msg         string `json:"msg"`
+				Service     string `json:"service"`
+				HTTPRequest struct {
+					Method string            `json:"method"`
+					Header map[string]string `json:"header"`
+				} `json:"httpRequest"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("expected a single json log, got %q: %v", buf.String(), err)
+			}
+			if record.Level != tt.want || record.Msg != "Request: GET /" {
+				t.Fatalf("expected level %v, got %q", tt.want, buf.String())
+			}
+			if record.Service != "test" || record.HTTPRequest.Method != "GET" || record.HTTPRequest.Header["accept"] != "*/*" {
+				t.Fatalf("expected nested fields, got %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestHandlerEnabled(t *testing.T) {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&bytes.Buffer{}), zapcore.WarnLevel)
+	h := New(zap.New(core))
+	if h.Enabled(context.Background(), slog.LevelInfo) || !h.Enabled(context.Background(), slog.LevelWarn) {
+		t.Fatalf("expected the level of the zap core to apply")
+	}
+}
@@ -0,0 +1,148 @@
+// Package zerologhandler provides a slog.Handler which writes through a
+// zerolog logger, for the httplog request logger to be used by applications
+// whose logging is built on zerolog. It's a module of its own, so that httplog
+// doesn't depend on zerolog.
+package zerologhandler
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rs/zerolog"
+)
+
+// New returns a slog.Handler which writes through the zerolog logger, so the
+// request logger can be used by applications whose logging is built on
+// zerolog, ie.
+//
+//	logger := httplog.NewLoggerWithHandler("api", zerologhandler.New(zerologLogger), opts)
+//
+// Levels are mapped to the closest zerolog level, attributes to zerolog
+// fields and groups to nested objects. Timestamps are left to zerolog, ie.
+// with zerolog.Logger.With().Timestamp().
+func New(logger zerolog.Logger) slog.Handler {
+	return &zerologHandler{logger: logger, attrs: [][]slog.Attr{nil}}
+}
+
+type zerologHandler struct {
+	logger zerolog.Logger
+	groups []string
+	// attrs[i] are the attributes added within the first i groups, which are
+	// only written along with records, as zerolog can't add to an object
+	// once written
+	attrs [][]slog.Attr
+}
+
+var _ slog.Handler = &zerologHandler{}
+
+func (h *zerologHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	l := zerologLevel(level)
+	return l >= h.logger.GetLevel() && l >= zerolog.GlobalLevel()
+}
+
+func (h *zerologHandler) Handle(ctx context.Context, r slog.Record) error {
+	last := len(h.attrs) - 1
+	attrs := append(h.attrs[:last:last], append([]slog.Attr{}, h.attrs[last]...))
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[last] = append(attrs[last], a)
+		return true
+	})
+
+	appendGroups(h.logger.WithLevel(zerologLevel(r.Level)), h.groups, attrs).Msg(r.Message)
+	return nil
+}
+
+func (h *zerologHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	last := len(h.attrs) - 1
+	h2 := *h
+	h2.attrs = append(h.attrs[:last:last], append(h.attrs[last][:len(h.attrs[last]):len(h.attrs[last])], attrs...))
+	return &h2
+}
+
+func (h *zerologHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &zerologHandler{
+		logger: h.logger,
+		groups: append(h.groups[:len(h.groups):len(h.groups)], name),
+		attrs:  append(h.attrs[:len(h.attrs):len(h.attrs)], nil),
+	}
+}
+
+func zerologLevel(level slog.Level) zerolog.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return zerolog.DebugLevel
+	case level < slog.LevelWarn:
+		return zerolog.InfoLevel
+	case level < slog.LevelError:
+		return zerolog.WarnLevel
+	default:
+		return zerolog.ErrorLevel
+	}
+}
+
+// appendGroups adds the attributes of each level of groups to e, nesting the
+// groups as dictionaries. Groups without any attribute are left out.
+func appendGroups(e *zerolog.Event, groups []string, attrs [][]slog.Attr) *zerolog.Event {
+	for _, a := range attrs[0] {
+		e = appendAttr(e, a)
+	}
+	if len(groups) == 0 || !hasAttrs(attrs[1:]) {
+		return e
+	}
+	return e.Dict(groups[0], appendGroups(zerolog.Dict(), groups[1:], attrs[1:]))
+}
+
+func hasAttrs(attrs [][]slog.Attr) bool {
+	for _, level := range attrs {
+		if len(level) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func appendAttr(e *zerolog.Event, a slog.Attr) *zerolog.Event {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return e
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		if a.Key == "" {
+			// inline the attributes of groups without a key, as slog does
+			for _, ga := range group {
+				e = appendAttr(e, ga)
+			}
+			return e
+		}
+		if len(group) == 0 {
+			return e
+		}
+		d := zerolog.Dict()
+		for _, ga := range group {
+			d = appendAttr(d, ga)
+		}
+		return e.Dict(a.Key, d)
+	case slog.KindString:
+		return e.Str(a.Key, a.Value.String())
+	case slog.KindBool:
+		return e.Bool(a.Key, a.Value.Bool())
+	case slog.KindInt64:
+		return e.Int64(a.Key, a.Value.Int64())
+	case slog.KindUint64:
+		return e.Uint64(a.Key, a.Value.Uint64())
+	case slog.KindFloat64:
+		return e.Float64(a.Key, a.Value.Float64())
+	case slog.KindDuration:
+		return e.Dur(a.Key, a.Value.Duration())
+	case slog.KindTime:
+		return e.Time(a.Key, a.Value.Time())
+	default:
+		return e.Interface(a.Key, a.Value.Any())
+	}
+}
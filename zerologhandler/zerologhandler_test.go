@@ -0,0 +1,63 @@
+package zerologhandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  string
+	}{
+		{slog.LevelDebug, "debug"},
+		{slog.LevelInfo, "info"},
+		{slog.LevelWarn, "warn"},
+		{slog.LevelError, "error"},
+		{slog.LevelError + 4, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			logger := slog.New(New(zerolog.New(buf).Level(zerolog.DebugLevel)))
+			logger.With("service", "test").WithGroup("httpRequest").Log(context.Background(), tt.level, "Request: GET /",
+				slog.String("method", "GET"), slog.Group("header", slog.String("accept", "*/*")))
+
+			var record struct {
+				Level       string `json:"level"`
+				Service     string `json:"service"`
+				HTTPRequest struct {
+					Method string            `json:"method"`
+					Header map[string]string `json:"header"`
+				} `json:"httpRequest"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("expected a single json log, got %q: %v", buf.String(), err)
+			}
+			if record.Level != tt.want {
+				t.Fatalf("expected level %v, got %v", tt.want, record.Level)
+			}
+			if record.Service != "test" || record.HTTPRequest.Method != "GET" || record.HTTPRequest.Header["accept"] != "*/*" {
+				t.Fatalf("expected nested fields, got %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestHandlerGroupAttrs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(New(zerolog.New(buf)))
+	logger.WithGroup("httpResponse").With("status", 200).WithGroup("empty").Info("Response: 200 OK", slog.Int("bytes", 2))
+	logger.WithGroup("empty").Info("Response: 200 OK")
+
+	want := `{"level":"info","httpResponse":{"status":200,"empty":{"bytes":2}},"message":"Response: 200 OK"}` + "\n" +
+		`{"level":"info","message":"Response: 200 OK"}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("expected %q, got %q", want, buf.String())
+	}
+}